@@ -0,0 +1,300 @@
+package freak
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	html_parser "golang.org/x/net/html"
+)
+
+const (
+	dataFreakTextAttr     = "data-freak-text"
+	dataFreakIfAttr       = "data-freak-if"
+	dataFreakAttrPrefix   = "data-freak-attr-"
+	bindingPathSeparator  = "."
+	bindingNegationPrefix = "!"
+)
+
+// getter reads the value of a binding from the data of a component.
+type getter func(data reflect.Value) reflect.Value
+
+// processBindings turns the binding attributes of an element into callbacks
+// of its marker. The paths of the bindings name fields or methods of T, and
+// are resolved once, here.
+//
+//	data-freak-text="Title"         replaces the content with the value
+//	data-freak-attr-href="URL"      sets the attribute to the value
+//	data-freak-if="!User.IsAdmin"   renders the element only if the value is set
+func (c *component[T]) processBindings(node *html_parser.Node, m *marker[T]) *marker[T] {
+	var dataType = reflect.TypeOf((*T)(nil)).Elem()
+
+	var cond getter
+	var negate bool
+	var text getter
+	var attrNames []string
+	var attrGetters []getter
+
+	var kept = node.Attr[:0]
+
+	for _, attr := range node.Attr {
+		var key = strings.ToLower(attr.Key)
+
+		switch {
+		case key == dataFreakIfAttr:
+			var path = strings.TrimSpace(attr.Val)
+			negate = strings.HasPrefix(path, bindingNegationPrefix)
+			cond = c.bindingGetter(node, dataType, key, strings.TrimPrefix(path, bindingNegationPrefix))
+
+		case key == dataFreakTextAttr:
+			text = c.bindingGetter(node, dataType, key, attr.Val)
+
+		case strings.HasPrefix(key, dataFreakAttrPrefix) && len(key) > len(dataFreakAttrPrefix):
+			attrNames = append(attrNames, key[len(dataFreakAttrPrefix):])
+			attrGetters = append(attrGetters, c.bindingGetter(node, dataType, key, attr.Val))
+
+		default:
+			kept = append(kept, attr)
+		}
+	}
+
+	if cond == nil && text == nil && len(attrGetters) == 0 {
+		return m
+	}
+
+	// A bound attribute replaces the static one of the same name
+	node.Attr = kept[:0]
+	for _, attr := range kept {
+		if !containsFold(attrNames, attr.Key) {
+			node.Attr = append(node.Attr, attr)
+		}
+	}
+
+	if m == nil {
+		m = &marker[T]{}
+		c.markers = append(c.markers, m)
+	}
+
+	if cond != nil {
+		var userPre = m.callbacks[preCallbackIndex].callback
+
+		m.callbacks[preCallbackIndex].callback = func(r *response[T], data T) {
+			if isTruthy(cond(reflect.ValueOf(data))) == negate {
+				r.SkipElement()
+				return
+			}
+
+			if userPre != nil {
+				userPre(r, data)
+			}
+		}
+	}
+
+	if len(attrGetters) != 0 {
+		var userAttrs = m.callbacks[attrCallbackIndex].callback
+
+		m.callbacks[attrCallbackIndex].callback = func(r *response[T], data T) {
+			var v = reflect.ValueOf(data)
+
+			for i, get := range attrGetters {
+				writeAttr[T](r.writer, attrNames[i], bindingString(get(v)), true)
+			}
+
+			if userAttrs != nil {
+				userAttrs(r, data)
+			}
+		}
+	}
+
+	if text != nil {
+		var userPost = m.callbacks[postCallbackIndex].callback
+
+		m.callbacks[postCallbackIndex].callback = func(r *response[T], data T) {
+			r.WriteString(bindingString(text(reflect.ValueOf(data))))
+			r.SkipContent()
+
+			if userPost != nil {
+				userPost(r, data)
+			}
+		}
+	}
+
+	return m
+}
+
+// bindingGetter resolves a path like "Author.Name" against the type. Each part
+// is a field, or a method without arguments that returns one value. Pointers
+// are followed, and a nil one gives an invalid Value. Problems are reported to
+// the compiler, at the attribute of the node.
+func (c *component[T]) bindingGetter(node *html_parser.Node, t reflect.Type, attrKey, path string) getter {
+	var steps []bindingStep
+
+	for _, name := range strings.Split(strings.TrimSpace(path), bindingPathSeparator) {
+		var step, next, err = resolveBindingStep(t, name)
+		if err != nil {
+			c.compiling.add(c.compiling.attrOffset(node, attrKey), "%s: %s", attrKey, err)
+			return func(reflect.Value) reflect.Value { return reflect.Value{} }
+		}
+
+		// FieldByIndexErr follows a pointer between fields, so a run of fields
+		// is one step
+		if last := len(steps) - 1; last != -1 && steps[last].index != nil && step.index != nil && step.derefs <= 1 {
+			steps[last].index = append(steps[last].index, step.index...)
+		} else {
+			steps = append(steps, step)
+		}
+
+		t = next
+	}
+
+	return func(v reflect.Value) reflect.Value {
+		for i := range steps {
+			if v = steps[i].get(v); !v.IsValid() {
+				break
+			}
+		}
+		return v
+	}
+}
+
+// bindingStep is one resolved part of a path. It follows fields by their
+// indexes, or calls the method at `method` when there are none.
+type bindingStep struct {
+	derefs    int   // pointers to follow first
+	index     []int // fields to follow, through any pointers between them
+	method    int
+	ptrMethod bool // the method is of the pointer
+}
+
+func (s *bindingStep) get(v reflect.Value) reflect.Value {
+	if v = derefValue(v, s.derefs); !v.IsValid() {
+		return v
+	}
+
+	if s.index != nil {
+		v, err := v.FieldByIndexErr(s.index)
+		if err != nil {
+			return reflect.Value{} // Through a nil pointer
+		}
+		return v
+	}
+
+	if s.ptrMethod {
+		if !v.CanAddr() {
+			var cp = reflect.New(v.Type()).Elem()
+			cp.Set(v)
+			v = cp
+		}
+		v = v.Addr()
+	}
+
+	return v.Method(s.method).Call(nil)[0]
+}
+
+// resolveBindingStep resolves one part of a path, and returns the type it
+// gives. Interfaces can't be gone through, since their fields and methods are
+// only known at runtime.
+func resolveBindingStep(t reflect.Type, name string) (bindingStep, reflect.Type, error) {
+	var step bindingStep
+	var base = t
+
+	for base.Kind() == reflect.Pointer {
+		base = base.Elem()
+		step.derefs++
+	}
+
+	if base.Kind() == reflect.Interface {
+		return step, nil, fmt.Errorf("%s is an interface, so %s can't be found in it", base, name)
+	}
+
+	// Methods of the value are found first. Those of the pointer need an
+	// addressable value, or else a copy is made.
+	var method, ok = base.MethodByName(name)
+	if !ok {
+		if method, ok = reflect.PointerTo(base).MethodByName(name); ok {
+			step.ptrMethod = true
+		}
+	}
+
+	if ok {
+		var mt = method.Type
+		if mt.NumIn() != 1 || mt.NumOut() != 1 {
+			return step, nil, fmt.Errorf("method %s of %s must have no arguments and one result", name, base)
+		}
+
+		step.method = method.Index
+		return step, mt.Out(0), nil
+	}
+
+	if base.Kind() != reflect.Struct {
+		return step, nil, fmt.Errorf("%s has no method %s", base, name)
+	}
+
+	field, ok := base.FieldByName(name)
+	if !ok || !field.IsExported() {
+		return step, nil, fmt.Errorf("%s has no exported field or method %s", base, name)
+	}
+
+	step.index = append([]int(nil), field.Index...) // Copied, since runs of fields are appended to
+	return step, field.Type, nil
+}
+
+// derefValue follows `n` pointers. It gives an invalid Value for a nil one.
+func derefValue(v reflect.Value, n int) reflect.Value {
+	for ; n > 0; n-- {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// isTruthy reports if a value is set. Lengths are checked for what has one,
+// so that an empty but non-nil slice is not set.
+func isTruthy(v reflect.Value) bool {
+	if !v.IsValid() {
+		return false
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String, reflect.Chan:
+		return v.Len() != 0
+	default:
+		return !v.IsZero()
+	}
+}
+
+// bindingString formats a value for the output. It's escaped by the caller.
+func bindingString(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return ""
+		}
+	}
+
+	return fmt.Sprint(v.Interface())
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,373 @@
+package freak
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache configures the output cache of a Route. A cached page is rendered
+// once per key, and both its plain and gzipped bytes are kept until the TTL
+// runs out.
+type Cache struct {
+	// TTL is how long a rendered page is served without rendering it again.
+	TTL time.Duration
+
+	// StaleWhileRevalidate is how long an expired page may still be served
+	// while a fresh copy is rendered in the background.
+	StaleWhileRevalidate time.Duration
+
+	// IgnoreQuery leaves the query string of the URL out of the cache key.
+	// Only set it if the handler doesn't read the query.
+	IgnoreQuery bool
+
+	// KeyHeaders and KeyCookies name the request headers and cookies whose
+	// values are added to the cache key. The path is always part of the key.
+	KeyHeaders []string
+	KeyCookies []string
+
+	// Tags are attached to every page cached for the route, so they can be
+	// removed with Server.InvalidateCache. Handlers can add more tags with
+	// RouteResponse.CacheTags.
+	Tags []string
+}
+
+type outputCache struct {
+	config Cache
+	vary   []string
+
+	// negotiated is set once a page of the route varied on the Accept header.
+	// From then on, the header is part of the key.
+	negotiated     int32
+	varyNegotiated []string
+
+	mux       sync.RWMutex
+	entries   map[string]*cacheEntry
+	lastSweep time.Time
+}
+
+type cacheEntry struct {
+	raw, gzipped       []byte
+	rawTag, gzippedTag string // ETags, if enabled for the route
+	header             http.Header
+
+	tags []string
+
+	expires, staleUntil time.Time
+
+	revalidating int32
+}
+
+func newOutputCache(config Cache, canGzip bool) *outputCache {
+	var c = outputCache{
+		config:    config,
+		entries:   map[string]*cacheEntry{},
+		lastSweep: time.Now(),
+	}
+
+	if canGzip {
+		c.vary = append(c.vary, _acceptEncoding)
+	}
+	for _, hdr := range config.KeyHeaders {
+		c.vary = append(c.vary, http.CanonicalHeaderKey(hdr))
+	}
+	if len(config.KeyCookies) != 0 {
+		c.vary = append(c.vary, "Cookie")
+	}
+
+	// Limit the capacity, so that a handler adding to the Vary header of its
+	// response doesn't write into this shared slice.
+	c.vary = c.vary[0:len(c.vary):len(c.vary)]
+
+	c.varyNegotiated = append(c.vary, _accept)
+	c.varyNegotiated = c.varyNegotiated[0:len(c.varyNegotiated):len(c.varyNegotiated)]
+
+	return &c
+}
+
+// serves reports if the request may be answered from the cache. Only GET and
+// HEAD are, so that other methods always reach the handler.
+func (c *outputCache) serves(req *http.Request) bool {
+	return c != nil && isSafeMethod(req.Method)
+}
+
+// key builds the cache key for the request. Each part is separated by a NUL
+// so that values can't run into each other.
+func (c *outputCache) key(req *http.Request) string {
+	var negotiated = c.isNegotiated()
+
+	var query = req.URL.RawQuery
+	if c.config.IgnoreQuery {
+		query = ""
+	}
+
+	if len(c.config.KeyHeaders) == 0 && len(c.config.KeyCookies) == 0 && !negotiated {
+		if query == "" {
+			return req.URL.Path
+		}
+		return req.URL.Path + "?" + query
+	}
+
+	var b strings.Builder
+
+	b.WriteString(req.URL.Path)
+	b.WriteByte('?')
+	b.WriteString(query)
+
+	for _, hdr := range c.config.KeyHeaders {
+		b.WriteByte(0)
+		b.WriteString(req.Header.Get(hdr))
+	}
+
+	for _, name := range c.config.KeyCookies {
+		b.WriteByte(0)
+		if cookie, err := req.Cookie(name); err == nil {
+			b.WriteString(cookie.Value)
+		}
+	}
+
+	if negotiated {
+		b.WriteByte(0)
+		b.WriteString(req.Header.Get(_accept))
+	}
+
+	return b.String()
+}
+
+func (c *outputCache) isNegotiated() bool {
+	return atomic.LoadInt32(&c.negotiated) == 1
+}
+
+// varyHeader is the Vary header of the route's pages.
+func (c *outputCache) varyHeader() []string {
+	if c.isNegotiated() {
+		return c.varyNegotiated
+	}
+	return c.vary
+}
+
+// get returns the entry for the key, and whether it is still fresh. A stale
+// entry is only returned while it is within the StaleWhileRevalidate window.
+func (c *outputCache) get(key string, now time.Time) (*cacheEntry, bool) {
+	c.mux.RLock()
+	var e = c.entries[key]
+	c.mux.RUnlock()
+
+	switch {
+	case e == nil:
+		return nil, false
+	case now.Before(e.expires):
+		return e, true
+	case now.Before(e.staleUntil):
+		return e, false
+	default:
+		return nil, false
+	}
+}
+
+func (c *outputCache) put(key string, e *cacheEntry, now time.Time) {
+	e.expires = now.Add(c.config.TTL)
+	e.staleUntil = e.expires.Add(c.config.StaleWhileRevalidate)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	// Expired entries are swept on the way in, at most once per TTL
+	if now.Sub(c.lastSweep) > c.config.TTL {
+		c.lastSweep = now
+
+		for k, old := range c.entries {
+			if !now.Before(old.staleUntil) {
+				delete(c.entries, k)
+			}
+		}
+	}
+
+	c.entries[key] = e
+}
+
+func (c *outputCache) invalidate(tags []string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for k, e := range c.entries {
+	TAGS:
+		for _, entryTag := range e.tags {
+			for _, tag := range tags {
+				if entryTag == tag {
+					delete(c.entries, k)
+					break TAGS
+				}
+			}
+		}
+	}
+}
+
+// cachedHeaders are the response headers that are stored with a page, since
+// they must match its content. A CSP header is not, since pages with a nonce
+// are never cached, and the security headers are set for each request.
+var cachedHeaders = [...]string{_contentType}
+
+func (e *cacheEntry) send(resp http.ResponseWriter, req *http.Request, doGzip bool) {
+	for key, val := range e.header {
+		resp.Header()[key] = val
+	}
+
+	if doGzip && e.gzipped != nil {
+		resp.Header()[_contentEncoding] = gzipHeader
+		sendBody(resp, req, e.gzipped, e.gzippedTag)
+
+	} else {
+		sendBody(resp, req, e.raw, e.rawTag)
+	}
+}
+
+// clear removes every entry.
+func (c *outputCache) clear() {
+	c.mux.Lock()
+	c.entries = map[string]*cacheEntry{}
+	c.mux.Unlock()
+}
+
+// clearCaches empties the output cache of every route.
+func (s *server) clearCaches() {
+	for _, fh := range s.routes {
+		if fh.cache != nil {
+			fh.cache.clear()
+		}
+	}
+}
+
+func (s *server) invalidateCache(tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+
+	var seen = map[*freakHandler]bool{}
+
+	for _, fh := range s.routes {
+		if fh.cache != nil && !seen[fh] {
+			seen[fh] = true
+			fh.cache.invalidate(tags)
+		}
+	}
+}
+
+// serveCached serves a route that has an output cache. On a miss the page is
+// rendered without compression, then stored both plain and gzipped. A stale
+// hit is served as is while one request renders a fresh copy in the background.
+func (s *server) serveCached(resp http.ResponseWriter, req *http.Request, fh *freakHandler, doGzip bool) {
+	var c = fh.cache
+	var key = c.key(req)
+	var now = time.Now()
+
+	if vary := c.varyHeader(); len(vary) != 0 {
+		resp.Header()[_vary] = vary
+	}
+
+	var entry, fresh = c.get(key, now)
+
+	if entry == nil {
+		entry = s.renderForCache(resp, req, fh)
+		if entry == nil {
+			return // The page was not cacheable, and has already been sent
+		}
+		c.put(key, entry, now)
+
+	} else if !fresh && atomic.CompareAndSwapInt32(&entry.revalidating, 0, 1) {
+		go s.revalidate(req.Clone(context.Background()), fh, key, entry)
+	}
+
+	entry.send(resp, req, doGzip)
+}
+
+func (s *server) revalidate(req *http.Request, fh *freakHandler, key string, stale *cacheEntry) {
+	var entry = s.renderForCache(
+		&discardResponse{header: http.Header{_contentType: htmlContentHeader}}, req, fh,
+	)
+
+	if entry == nil {
+		atomic.StoreInt32(&stale.revalidating, 0) // Let a later request try again
+		return
+	}
+
+	fh.cache.put(key, entry, time.Now())
+}
+
+// renderForCache runs the route's handler, and returns the new entry. If the
+// handler sent its own response, or the response must not be cached, then it
+// is sent normally and `nil` is returned.
+func (s *server) renderForCache(
+	resp http.ResponseWriter, req *http.Request, fh *freakHandler,
+) *cacheEntry {
+
+	var r = getResponse(s, resp, req, fh.siteMapNode, false)
+	defer putResponse(s, r)
+
+	s.runHandler(r, fh)
+
+	if r.responseState.has(sent) {
+		return nil
+	}
+
+	r.commit() // Any cookies or session changes make the page uncacheable
+
+	// A page that was negotiated on the Accept header is only stored under
+	// a key that has it
+	if containsFold(resp.Header()[_vary], _accept) && !fh.cache.isNegotiated() {
+		atomic.StoreInt32(&fh.cache.negotiated, 1)
+		r.responseState.set(skipCache)
+	}
+
+	if r.responseState.has(skipCache) || len(resp.Header()["Set-Cookie"]) != 0 {
+		r.send(fh.route.ETag)
+		return nil
+	}
+
+	var entry = cacheEntry{
+		raw:    append([]byte(nil), r.buf.Bytes()...),
+		tags:   append(append([]string(nil), fh.cache.config.Tags...), r.cacheTags...),
+		header: http.Header{},
+	}
+
+	for _, key := range cachedHeaders {
+		if val, ok := resp.Header()[key]; ok {
+			entry.header[key] = val
+		}
+	}
+
+	if s.compressionLevel != 0 {
+		var gzipped bytes.Buffer
+
+		r.gzip.Reset(&gzipped)
+		r.gzip.Write(entry.raw)
+		r.gzip.Close()
+
+		entry.gzipped = gzipped.Bytes()
+	}
+
+	if fh.route.ETag != NoETag {
+		entry.rawTag = fh.route.ETag.tag(entry.raw)
+
+		if entry.gzipped != nil {
+			entry.gzippedTag = fh.route.ETag.tag(entry.gzipped)
+		}
+	}
+
+	r.responseState.set(sent) // The entry is sent in place of the buffer
+
+	return &entry
+}
+
+// discardResponse receives the response of a background render.
+type discardResponse struct {
+	header http.Header
+}
+
+func (d *discardResponse) Header() http.Header         { return d.header }
+func (d *discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponse) WriteHeader(int)             {}
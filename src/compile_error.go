@@ -0,0 +1,219 @@
+package freak
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	html_parser "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// CompileError lists every problem that was found while compiling the HTML of
+// a component.
+type CompileError struct {
+	// Template is the name of the file given to HTMLFile, or "component" for
+	// HTML from a string.
+	Template string
+	Problems []Problem
+}
+
+// Problem is one problem of a CompileError. Its Line and Column start at 1,
+// and are 0 when the problem has no place in the HTML, like a Marker that is
+// given twice.
+type Problem struct {
+	Line, Column int
+	Message      string
+}
+
+func (e *CompileError) Error() string {
+	var b strings.Builder
+
+	for i, p := range e.Problems {
+		if i != 0 {
+			b.WriteByte('\n')
+		}
+
+		b.WriteString(e.Template)
+
+		if p.Line != 0 {
+			fmt.Fprintf(&b, ":%d:%d", p.Line, p.Column)
+		}
+
+		b.WriteString(": ")
+		b.WriteString(p.Message)
+	}
+
+	return b.String()
+}
+
+const _defaultTemplateName = "component"
+
+// compiler collects the problems of a component while its HTML is compiled.
+// Since the parsed nodes have no positions, the tags of the source are matched
+// to them by locate.
+type compiler struct {
+	template string
+	source   string
+	problems []Problem
+
+	tags      map[*html_parser.Node]sourceTag
+	endOffset int // where the tokenizer stopped
+	slots     map[string]bool
+
+	overflowOffset int // where the compiled HTML grew too large, or -1
+}
+
+// sourceTag is the place of a start tag or comment in the source.
+type sourceTag struct {
+	start, end int
+}
+
+func newCompiler(template, source string) *compiler {
+	if len(template) == 0 {
+		template = _defaultTemplateName
+	}
+	return &compiler{template: template, source: source, overflowOffset: -1}
+}
+
+// add records a problem at the byte `offset` of the source. A negative offset
+// has no position.
+func (cp *compiler) add(offset int, format string, args ...any) {
+	var p = Problem{Message: fmt.Sprintf(format, args...)}
+
+	if offset >= 0 {
+		var before = cp.source[:offset]
+
+		p.Line = strings.Count(before, "\n") + 1
+		p.Column = offset - strings.LastIndexByte(before, '\n')
+	}
+
+	cp.problems = append(cp.problems, p)
+}
+
+// Elements that the parser may add without a tag in the source.
+var impliedElements = map[atom.Atom]bool{
+	atom.Html: true, atom.Head: true, atom.Body: true,
+	atom.Tbody: true, atom.Tr: true, atom.Colgroup: true,
+}
+
+// locate matches the elements and comments of the parsed tree to the start
+// tags and comments of the source, which come in the same order. Elements
+// that the parser added are only matched by the very next tag, and tags that
+// the parser dropped are passed over.
+func (cp *compiler) locate(root *html_parser.Node) {
+	type token struct {
+		sourceTag
+		typ  html_parser.TokenType
+		data string
+	}
+
+	var tokens []token
+	var z = html_parser.NewTokenizer(strings.NewReader(cp.source))
+
+	for offset := 0; ; {
+		var typ = z.Next()
+		if typ == html_parser.ErrorToken {
+			cp.endOffset = offset
+			break
+		}
+
+		var end = offset + len(z.Raw())
+
+		switch typ {
+		case html_parser.StartTagToken, html_parser.SelfClosingTagToken, html_parser.CommentToken:
+			var tok = z.Token()
+			tokens = append(tokens, token{sourceTag{offset, end}, typ, tok.Data})
+		}
+
+		offset = end
+	}
+
+	cp.tags = map[*html_parser.Node]sourceTag{}
+
+	var next = 0
+
+	var walk func(n *html_parser.Node)
+	walk = func(n *html_parser.Node) {
+		for ; n != nil; n = n.NextSibling {
+			if n.Type == html_parser.ElementNode || n.Type == html_parser.CommentNode {
+				for i := next; i < len(tokens); i++ {
+					var tok = tokens[i]
+					var isComment = tok.typ == html_parser.CommentToken
+
+					if isComment == (n.Type == html_parser.CommentNode) && tok.data == n.Data {
+						cp.tags[n] = tok.sourceTag
+						next = i + 1
+						break
+					}
+
+					if n.Type == html_parser.ElementNode && impliedElements[n.DataAtom] && !isComment {
+						break
+					}
+				}
+			}
+
+			walk(n.FirstChild)
+		}
+	}
+
+	walk(root)
+}
+
+// nodeOffset is where the node starts in the source. A node that has no tag of
+// its own, like text or an element that the parser added, gets the place of
+// its closest parent that has one, or -1.
+func (cp *compiler) nodeOffset(n *html_parser.Node) int {
+	for ; n != nil; n = n.Parent {
+		if tag, ok := cp.tags[n]; ok {
+			return tag.start
+		}
+	}
+	return -1
+}
+
+// attrOffset finds the attribute in the element's tag. If it isn't found
+// there, the place of the element is given.
+func (cp *compiler) attrOffset(n *html_parser.Node, key string) int {
+	var tag, ok = cp.tags[n]
+	if !ok {
+		return cp.nodeOffset(n)
+	}
+
+	var re = regexp.MustCompile(`(?i)\s` + regexp.QuoteMeta(key) + `(\s*=|[\s/>]|$)`)
+
+	if loc := re.FindStringIndex(cp.source[tag.start:tag.end]); loc != nil {
+		return tag.start + loc[0] + 1
+	}
+	return tag.start
+}
+
+// checkSize records where the compiled HTML first grew past its limit. It's
+// called before each node is rendered.
+func (cp *compiler) checkSize(size int, n *html_parser.Node) {
+	if cp.overflowOffset == -1 && uint64(size) > maxHTMLSize {
+		cp.overflowOffset = cp.nodeOffset(n)
+	}
+}
+
+// addSlot records a slot, and reports if its name was not used before.
+func (cp *compiler) addSlot(name string, n *html_parser.Node) bool {
+	if cp.slots == nil {
+		cp.slots = map[string]bool{}
+	}
+
+	if !cp.slots[name] {
+		cp.slots[name] = true
+		return true
+	}
+
+	cp.add(cp.nodeOffset(n), "the %q slot is used more than once", name)
+	return false
+}
+
+func (cp *compiler) err() error {
+	if len(cp.problems) == 0 {
+		return nil
+	}
+	return &CompileError{Template: cp.template, Problems: cp.problems}
+}
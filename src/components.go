@@ -12,6 +12,7 @@ import (
 	"sync/atomic"
 
 	html_parser "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 var freakId uint32 = 0
@@ -21,7 +22,16 @@ func nextId() string {
 }
 
 var allCss, allJs bytes.Buffer
-var cssMux, jsMux sync.Mutex
+
+// The CSS and JS of each component is kept too, so that the aggregates can be
+// rebuilt when a component is reloaded in dev mode.
+var compAssets = map[string]*compAsset{}
+var compAssetOrder []string
+var assetMux sync.Mutex
+
+type compAsset struct {
+	css, js string
+}
 
 const _resDir = "/res/"
 
@@ -69,6 +79,65 @@ type Marker[T any] struct {
 
 type MarkerCallback[T any] func(*response[T], T)
 
+// userMarkers holds the Markers given to a component by their names, and
+// tracks which of them were found in the HTML.
+type userMarkers[T any] map[string]*userMarker[T]
+
+type userMarker[T any] struct {
+	Marker[T]
+	used bool
+}
+
+func newUserMarkers[T any](cp *compiler, markers []Marker[T]) userMarkers[T] {
+	var um = make(userMarkers[T], len(markers))
+
+	for _, m := range markers {
+		if um[m.Name] != nil {
+			cp.add(-1, "the %q marker is given more than once", m.Name)
+			continue
+		}
+		um[m.Name] = &userMarker[T]{Marker: m}
+	}
+
+	return um
+}
+
+// markSkipped marks the markers of the nodes that a Static callback left out
+// as used, since they are not missing from the HTML.
+func (um userMarkers[T]) markSkipped(node *html_parser.Node) {
+	for ; node != nil; node = node.NextSibling {
+		for _, attr := range node.Attr {
+			if m := um[attr.Val]; m != nil && strings.EqualFold(attr.Key, dataFreakAttr) {
+				m.used = true
+			}
+		}
+		um.markSkipped(node.FirstChild)
+	}
+}
+
+// checkUsed reports the markers that no element used.
+func (um userMarkers[T]) checkUsed(cp *compiler) {
+	var unused []string
+
+	for name, m := range um {
+		if !m.used {
+			unused = append(unused, name)
+		}
+	}
+
+	sort.Strings(unused)
+
+	for _, name := range unused {
+		cp.add(-1, "no data-freak attribute found for the %q marker", name)
+	}
+}
+
+// Static holds callbacks like Positions, but they run once, when the
+// component is compiled, and their output becomes part of its HTML. They get
+// the zero value of T, and no request. The Write methods, SkipElement,
+// SkipContent and AddAttr may be used. The request accessors give empty
+// values, and Nonce and CSRFToken fail the compile, since they belong to one
+// request.
 type Static[T any] [3]do[T]
 
 type Positions[T any] [3]do[T]
@@ -104,54 +173,70 @@ const ( // For the 'callbacks' field of 'marker'
 type marker[T any] struct {
 	callbacks                    [3]callbackPos[T] // for pre, attrs, post
 	containsWrapperContentMarker bool
+	isSlot                       bool // has no width, so its endPos may be 0
+	nonceOnly                    bool // only adds the CSP nonce, so it's skipped without one
 }
 
+// callbackPos holds the offsets in the compiled HTML of a marker callback. The
+// offsets are 32 bits, which keeps this the same size as with 16 bits, since
+// the callback aligns it to 8 bytes anyway.
 type callbackPos[T any] struct {
 	callback    MarkerCallback[T]
-	pos, endPos uint16
+	pos, endPos uint32
 }
 
 type wrapperEndingAndIndex struct {
 	ending func()
-	index  uint16
+	index  uint32
 }
 
 func addToCssJs(id string, css css, js js) {
-	if len(css.css) == 0 {
-		goto doJS
+	var asset compAsset
+
+	if len(css.css) != 0 {
+		asset.css = strings.ReplaceAll(
+			css.css,
+			":root",
+			fmt.Sprintf(`[data-freak^=%q]`, id+":"),
+		)
 	}
 
-	css.css = strings.ReplaceAll(
-		css.css,
-		":root",
-		fmt.Sprintf(`[data-freak^=%q]`, id+":"),
-	)
+	if len(js.js) != 0 {
+		var newJS = strings.Replace(js.js, "export default", "return ", 1)
+		asset.js = fmt.Sprintf("[%q,freak=>{%s}],", id, newJS)
+	}
+
+	assetMux.Lock()
+	defer assetMux.Unlock()
 
-	cssMux.Lock()
-	defer cssMux.Unlock()
+	if compAssets[id] != nil { // Reloaded, so everything is rebuilt in order
+		*compAssets[id] = asset
 
-	allCss.WriteString(css.css)
+		allCss.Reset()
+		allJs.Reset()
 
-doJS:
-	if len(js.js) == 0 {
+		for _, id := range compAssetOrder {
+			allCss.WriteString(compAssets[id].css)
+			allJs.WriteString(compAssets[id].js)
+		}
 		return
 	}
 
-	var newJS = strings.Replace(js.js, "export default", "return ", 1)
-	newJS = fmt.Sprintf("[%q,freak=>{%s}],", id, newJS)
-
-	jsMux.Lock()
-	defer jsMux.Unlock()
+	compAssets[id] = &asset
+	compAssetOrder = append(compAssetOrder, id)
 
-	allJs.WriteString(newJS)
+	allCss.WriteString(asset.css)
+	allJs.WriteString(asset.js)
 }
 
 type css struct {
 	css string
+	src *source // for dev mode
 }
 
 type js struct {
-	js string
+	js  string
+	src *source // for dev mode
 }
 
 func fileToString(f fs.File) string {
@@ -161,19 +246,37 @@ func fileToString(f fs.File) string {
 }
 
 func CSS(s string) css {
-	return css{s}
+	return css{css: s}
 }
 
+// CSSFile reads the CSS from the file. In dev mode, an *os.File is watched and
+// reloaded.
 func CSSFile(f fs.File) css {
-	return CSS(fileToString(f))
+	return css{css: fileToString(f), src: fileSource(f)}
+}
+
+// CSSFS reads the CSS from the file system. In dev mode, the file is watched
+// and reloaded.
+func CSSFS(fsys fs.FS, name string) css {
+	var src = &source{fsys: fsys, name: name}
+	return css{css: src.mustRead(), src: src}
 }
 
 func JS(s string) js {
-	return js{s}
+	return js{js: s}
 }
 
+// JSFile reads the JS from the file. In dev mode, an *os.File is watched and
+// reloaded.
 func JSFile(f fs.File) js {
-	return JS(fileToString(f))
+	return js{js: fileToString(f), src: fileSource(f)}
+}
+
+// JSFS reads the JS from the file system. In dev mode, the file is watched and
+// reloaded.
+func JSFS(fsys fs.FS, name string) js {
+	var src = &source{fsys: fsys, name: name}
+	return js{js: src.mustRead(), src: src}
 }
 
 func HTML(s string, compress HTMLCompress) *html {
@@ -184,24 +287,47 @@ func HTML(s string, compress HTMLCompress) *html {
 	}
 }
 
+// HTMLFile reads the HTML from the file. In dev mode, an *os.File is watched,
+// and the component is compiled again when it changes.
 func HTMLFile(f fs.File, compress HTMLCompress) *html {
-	return HTML(fileToString(f), compress)
+	var h = HTML(fileToString(f), compress)
+
+	if info, err := f.Stat(); err == nil {
+		h.name = info.Name()
+	}
+	h.src = fileSource(f)
+	return h
+}
+
+// HTMLFS reads the HTML from the file system. In dev mode, the file is watched,
+// and the component is compiled again when it changes.
+func HTMLFS(fsys fs.FS, name string, compress HTMLCompress) *html {
+	var src = &source{fsys: fsys, name: name}
+
+	var h = HTML(src.mustRead(), compress)
+	h.name = name
+	h.src = src
+	return h
 }
 
+// HeadMarker is the content of a head tag. For a link or script, and for the
+// Canonical and Description, it's the URL or text of the attribute. The
+// Dynamic callback is called for each request, and writes after the Static.
 type HeadMarker[T any] struct {
 	Static  string
 	Dynamic MarkerCallback[T]
 }
 
-type headMarker[T any] struct {
-	name    string
-	dynamic MarkerCallback[T]
-}
-
 type Head[T any] struct {
 	Title, Style, NoScript HeadMarker[T]
 	Link, Script, Template []HeadMarker[T]
 	Meta                   Meta
+
+	// Canonical is the URL of the canonical link.
+	Canonical HeadMarker[T]
+
+	// Description, if set, replaces the description of the Meta.
+	Description HeadMarker[T]
 }
 type Meta struct {
 	ApplicationName string
@@ -284,27 +410,77 @@ func (r Referrer) String() string {
 	}
 }
 
+const _pageBodyMarker = "freak-body"
+
+// NewPage builds a complete document from the head, the attributes of the
+// body element, and the `body` callback that renders the body's content. It
+// is compiled like a component, so the CSP nonce is added to its script and
+// style tags.
 func NewPage[T any](
 	h Head[T],
 	bodyAttrs map[string]string,
 	body func(r *RouteResponse, data T),
 ) Page[T] {
 
-	var headMarkers = []*headMarker[T]{}
+	var markers []Marker[T]
 	var html strings.Builder
 
+	// addHeadMarker names a marker for the Dynamic callback of a head tag, and
+	// returns the attributes with the marker added.
+	var addHeadMarker = func(
+		attrs map[string]string, pos do[T],
+	) map[string]string {
+		var name = fmt.Sprintf("freak-head-%d", len(markers))
+
+		var m = Marker[T]{Name: name}
+		if _, isAttrs := pos.(Attrs[T]); isAttrs {
+			m.Positions[attrCallbackIndex] = pos
+		} else {
+			m.Positions[postCallbackIndex] = pos
+		}
+		markers = append(markers, m)
+
+		var withMarker = map[string]string{dataFreakAttr: name}
+		for key, val := range attrs {
+			withMarker[key] = val
+		}
+		return withMarker
+	}
+
 	var addTag = func(
 		name string,
 		attrs map[string]string,
 		content *HeadMarker[T],
 		doCloser bool,
 		skipIfEmpty bool,
+		escapeContent bool,
 	) {
 
-		if skipIfEmpty && len(attrs) == 0 && (content == nil || (len(content.Static) == 0 && content.Dynamic == nil)) {
+		var hasContent = content != nil && (len(content.Static) != 0 || content.Dynamic != nil)
+
+		if skipIfEmpty && len(attrs) == 0 && !hasContent {
 			return
 		}
 
+		var static string
+		if content != nil {
+			static = content.Static
+			if escapeContent {
+				static = escapeHTMLString(static)
+			}
+		}
+
+		// With a Dynamic callback, the marker writes the Static content first
+		if content != nil && content.Dynamic != nil {
+			var dynamic, prefix = content.Dynamic, static
+
+			attrs = addHeadMarker(attrs, Post[T](func(r *response[T], data T) {
+				r.WriteStringNoEscape(prefix)
+				dynamic(r, data)
+			}))
+			static = ""
+		}
+
 		html.WriteByte('<')
 		html.WriteString(name)
 		for key, val := range attrs {
@@ -312,11 +488,7 @@ func NewPage[T any](
 		}
 		html.WriteByte('>')
 
-		html.WriteString(content.Static)
-
-		if content.Dynamic != nil {
-			// TODO: Add Marker
-		}
+		html.WriteString(static)
 
 		if doCloser {
 			html.WriteString("</")
@@ -325,61 +497,167 @@ func NewPage[T any](
 		}
 	}
 
+	// addURLTag adds a tag whose `urlAttr` holds the Static text followed by
+	// what the Dynamic callback writes.
+	var addURLTag = func(
+		name string, attrs map[string]string, urlAttr string, m HeadMarker[T], doCloser bool,
+	) {
+		if len(m.Static) == 0 && m.Dynamic == nil {
+			return
+		}
+
+		if m.Dynamic == nil {
+			attrs[urlAttr] = m.Static
+			addTag(name, attrs, nil, doCloser, false, false)
+			return
+		}
+
+		var static, dynamic = m.Static, m.Dynamic
+
+		attrs = addHeadMarker(attrs, Attrs[T](func(r *AttrResponse[T], data T) {
+			r.r.writer.Write(bytSpace)
+			r.r.WriteStringNoEscape(urlAttr)
+			r.r.writer.Write(bytEqualDblQuote)
+			r.r.WriteString(static)
+			dynamic(r.r, data)
+			r.r.writer.Write(bytQuote)
+		}))
+
+		addTag(name, attrs, nil, doCloser, false, false)
+	}
+
 	html.WriteString(`<!doctype html><html><head>`)
 
-	addTag("title", nil, &h.Title, true, false)
+	addTag("title", nil, &h.Title, true, false, true)
 
-	html.WriteString(`<meta charset="UTF-8">`)
-	html.WriteString(h.Meta.String())
+	var meta = h.Meta
+	if len(h.Description.Static) != 0 || h.Description.Dynamic != nil {
+		meta.Description = "" // Replaced by the one below
+	}
+
+	html.WriteString(meta.String())
+
+	addURLTag("meta", map[string]string{"name": "description"}, "content", h.Description, false)
 
-	addTag("style", nil, &h.Style, true, true)
+	addURLTag("link", map[string]string{"rel": "canonical"}, "href", h.Canonical, false)
+
+	addTag("style", nil, &h.Style, true, true, false)
 
 	for _, m := range h.Link {
-		addTag("link", map[string]string{"rel": "stylesheet", "href": m.Static}, nil, false, true)
+		addURLTag("link", map[string]string{"rel": "stylesheet"}, "href", m, false)
 	}
 
 	// For the accumulated CSS. The server responds directly with this.
-	addTag("link", map[string]string{"rel": "stylesheet", "href": _cssInsertionPath}, nil, false, true)
+	addTag("link", map[string]string{"rel": "stylesheet", "href": _cssInsertionPath}, nil, false, true, false)
 
 	for _, m := range h.Script {
-		addTag("script", map[string]string{"src": m.Static}, nil, true, true)
+		addURLTag("script", map[string]string{}, "src", m, true)
 	}
 
 	// For the accumulated JS. The server responds directly with this
-	addTag("script", map[string]string{"src": _jsInsertionPath}, nil, true, true)
+	addTag("script", map[string]string{"src": _jsInsertionPath}, nil, true, true, false)
 
-	addTag("noscript", nil, &h.NoScript, true, true)
+	addTag("noscript", nil, &h.NoScript, true, true, false)
 
-	for _, m := range h.Template {
-		addTag("template", nil, &m, false, true)
+	for i := range h.Template {
+		addTag("template", nil, &h.Template[i], true, true, false)
 	}
 
 	html.WriteString("</head>")
 
-	addTag("body", bodyAttrs, nil, true, false)
+	// The body's content is rendered by a marker on the body element
+	var attrs = map[string]string{dataFreakAttr: _pageBodyMarker}
+	for key, val := range bodyAttrs {
+		attrs[key] = val
+	}
+
+	addTag("body", attrs, nil, true, false, false)
+
+	markers = append(markers, Marker[T]{
+		Name: _pageBodyMarker,
+		Positions: Positions[T]{
+			Post[T](func(r *response[T], data T) {
+				body(&RouteResponse{r: asResponse[*RouteData](r)}, data)
+			}),
+		},
+	})
 
 	html.WriteString("</html>")
 
+	var c = component[T]{
+		compId:                    nextId(),
+		wrapperContentMarkerIndex: -1,
+	}
+	if err := c.processHTML("page", html.String(), htmlFlagHolder{}, markers); err != nil {
+		panic(err) // unreachable, since the document and its markers are generated
+	}
+
 	return Page[T]{
 		pageComponent: &pageComponent[T]{
-			page_head_markers: headMarkers,
-			body:              body,
+			component: &c,
 		},
 	}
 }
 
 type pageComponent[T any] struct {
-	page_head_markers []*headMarker[T]
-	body              func(*RouteResponse, T)
+	component *component[T]
 }
 
 type Page[T any] struct {
 	*pageComponent[T]
 }
 
-func (p *Page[T]) serve(r *RouteResponse, data T) {
-	// TODO: serve the page
-	p.body(r, data)
+// Serve renders the page with the given data as the response.
+func (p Page[T]) Serve(r *RouteResponse, data T) {
+	asResponse[T](r.r).insert(p.component, data, nil)
+}
+
+// Route returns a Route that serves the page with the data from `load`. If
+// `load` returns an error, the error page of its StatusError code is sent, or
+// the 500 page for any other error.
+func (p Page[T]) Route(rd RouteData, load func(*RouteResponse) (T, error)) Route {
+	return loaderRoute(rd, load, p.Serve)
+}
+
+// loaderRoute builds the Route of a page or component, which loads its data
+// before it's served.
+func loaderRoute[T any](
+	rd RouteData, load func(*RouteResponse) (T, error), serve func(*RouteResponse, T),
+) Route {
+	return Route{
+		RouteData: rd,
+		Handler: func(r *RouteResponse, _ *RouteData) {
+			data, err := load(r)
+			if err != nil {
+				r.SendError(errorStatus(err), err)
+				return
+			}
+
+			serve(r, data)
+		},
+	}
+}
+
+// Insert renders the component with the given data into the response.
+func Insert[T any](r *RouteResponse, c Component[T], data T) {
+	asResponse[T](r.r).insert(c.component, data, nil)
+}
+
+// InsertChild renders a component whose data type differs from the parent's.
+// A child that is a wrapper wraps the parent's content as it would with the
+// same type.
+func InsertChild[P, C any](r *response[P], c Component[C], data C) {
+	// The child's response shares the state of the parent's, so the wrapper
+	// endings that the child leaves are seen by the parent.
+	asResponse[C](r).insert(c.component, data, nil)
+}
+
+// Child returns a callback that inserts the component with data that is mapped
+// from the parent's. It's a Post position, and can be converted to a Pre.
+func Child[P, C any](c Component[C], mapData func(P) C) Post[P] {
+	return func(r *response[P], data P) {
+		InsertChild(r, c, mapData(data))
+	}
 }
 
 type component[T any] struct {
@@ -388,33 +666,70 @@ type component[T any] struct {
 	markers []*marker[T]
 
 	wrapperContentMarkerIndex int
-	wrapperContentHTMLIndex   uint16
+	wrapperContentHTMLIndex   uint32
 
 	compressionLevel htmlFlagHolder
+
+	compiling *compiler // only while the HTML is processed
 }
 
 type Component[T any] struct {
 	*component[T]
 }
 
-func (p *Component[T]) serve(r *RouteResponse, data T) {
-	// TODO: serve the component
+// Serve renders the component alone as the response. It has no document
+// around it, so it suits requests for a fragment of a page, as made by
+// scripts that enhance the page.
+func (c Component[T]) Serve(r *RouteResponse, data T) {
+	Insert(r, c, data)
 }
 
-func NewComponent[T any](css css, js js, html *html, markers ...Marker[T]) Component[T] {
+// Route returns a Route that serves the component as a fragment, with the data
+// from `load`. Errors are handled as they are by Page.Route.
+func (c Component[T]) Route(rd RouteData, load func(*RouteResponse) (T, error)) Route {
+	return loaderRoute(rd, load, c.Serve)
+}
+
+// CompileComponent compiles the HTML with its markers. If there are problems,
+// like a data-freak attribute without a marker, they are all returned in a
+// *CompileError, with their lines and columns in the HTML.
+func CompileComponent[T any](css css, js js, html *html, markers ...Marker[T]) (Component[T], error) {
 	var c = component[T]{
 		compId:                    nextId(),
 		wrapperContentMarkerIndex: -1,
 	}
 	html.compId = c.compId
+
+	if err := c.processHTML(html.name, html.in, html.level, markers); err != nil {
+		return Component[T]{}, err
+	}
+
 	addToCssJs(c.compId, css, js)
-	c.processHTML(html.in, html.level, markers)
 
-	return Component[T]{component: &c}
+	if css.src != nil || js.src != nil || html.src != nil {
+		addReloadable(&c, css, js, html, markers)
+	}
+
+	return Component[T]{component: &c}, nil
+}
+
+// MustNewComponent is like CompileComponent, but panics if there are problems.
+// It suits components that are created by package level variables.
+func MustNewComponent[T any](css css, js js, html *html, markers ...Marker[T]) Component[T] {
+	c, err := CompileComponent(css, js, html, markers...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// NewComponent is the same as MustNewComponent.
+func NewComponent[T any](css css, js js, html *html, markers ...Marker[T]) Component[T] {
+	return MustNewComponent(css, js, html, markers...)
 }
 
 func (c *component[T]) render(
-	root *html_parser.Node, buf *bytes.Buffer, isTop bool, markers []Marker[T],
+	root *html_parser.Node, buf *bytes.Buffer, isTop bool, markers userMarkers[T],
 ) {
 	var set_marker_pos = func(newMarker *marker[T], callbackIndex int, isStartPos bool) {
 		if newMarker == nil {
@@ -422,29 +737,39 @@ func (c *component[T]) render(
 		}
 
 		if isStartPos {
-			newMarker.callbacks[callbackIndex].pos = uint16(buf.Len())
+			newMarker.callbacks[callbackIndex].pos = uint32(buf.Len())
 		} else {
-			newMarker.callbacks[callbackIndex].endPos = uint16(buf.Len())
+			newMarker.callbacks[callbackIndex].endPos = uint32(buf.Len())
 		}
 	}
 
 	for currNode := root; currNode != nil; currNode = currNode.NextSibling {
+		c.compiling.checkSize(buf.Len(), currNode)
+
 		switch currNode.Type {
 
 		default:
 			html_parser.Render(buf, currNode)
 
 		case html_parser.CommentNode:
+			if name, ok := slotName(currNode.Data); ok {
+				if c.compiling.addSlot(name, currNode) {
+					c.markers = append(c.markers, newSlotMarker[T](name, uint32(buf.Len())))
+				}
+				continue
+			}
+
 			if strings.TrimSpace(currNode.Data) == "freak-wrapped-content" {
 				if c.wrapperContentMarkerIndex != -1 {
-					panic(`only one "freak-wrapped-content" is permitted in a component`)
+					c.compiling.add(c.compiling.nodeOffset(currNode), `only one "freak-wrapped-content" is permitted in a component`)
+					continue
 				}
 
 				c.wrapperContentMarkerIndex = len(c.markers)
-				c.wrapperContentHTMLIndex = uint16(buf.Len())
+				c.wrapperContentHTMLIndex = uint32(buf.Len())
 
 				for _, m := range c.markers {
-					if m.callbacks[preCallbackIndex].endPos == 0 {
+					if m.callbacks[preCallbackIndex].endPos == 0 && !m.isSlot {
 						// If the content marker is inside other markers, their 'endPos' will still be '0'
 						m.containsWrapperContentMarker = true
 					}
@@ -454,7 +779,7 @@ func (c *component[T]) render(
 			continue
 
 		case html_parser.ErrorNode, html_parser.RawNode:
-			panic(currNode.Data)
+			c.compiling.add(c.compiling.nodeOffset(currNode), "%s", currNode.Data)
 
 		case html_parser.DocumentNode:
 			// We want to traverse its children (probably !doctype and html)
@@ -462,8 +787,37 @@ func (c *component[T]) render(
 			return
 
 		case html_parser.ElementNode:
-			var newMarker *marker[T]
-			newMarker, markers = c.processFreakAttr(currNode, isTop, markers)
+			var newMarker, static = c.processFreakAttr(currNode, isTop, markers)
+
+			// Static output is baked in before the runtime output of each position
+			// Problems of the Static callbacks are reported at the marker
+			var runStaticAt = func(cb MarkerCallback[T]) (state[componentStateFlag], func()) {
+				var st, ending, err = runStatic(cb, buf)
+				if err != nil {
+					c.compiling.add(c.compiling.attrOffset(currNode, dataFreakAttr), "%s", err)
+				}
+				return st, ending
+			}
+
+			var preState, preEnding = runStaticAt(static[preCallbackIndex])
+
+			if preState.has(skipElement) {
+				if newMarker != nil {
+					c.markers = c.markers[:len(c.markers)-1]
+				}
+				markers.markSkipped(currNode.FirstChild)
+
+				if preEnding != nil {
+					preEnding() // There's no element left to wrap
+				}
+				continue
+			}
+
+			newMarker = c.processBindings(currNode, newMarker)
+
+			if nodeIsOneOf(currNode, atom.Script, atom.Style) || isStylesheetLink(currNode) {
+				newMarker = c.addNonceMarker(newMarker)
+			}
 
 			/*
 				if c.canElideOpener(currNode) {
@@ -514,18 +868,32 @@ func (c *component[T]) render(
 				// 		It would only be done on specific attrs for specific elems.
 			}
 
+			runStaticAt(static[attrCallbackIndex]) // An AttrResponse can't insert a wrapper
+
 			set_marker_pos(newMarker, attrCallbackIndex, true)
 			set_marker_pos(newMarker, attrCallbackIndex, false)
 
 			buf.WriteByte('>')
 
+			var staticState, postEnding = runStaticAt(static[postCallbackIndex])
+
 			set_marker_pos(newMarker, postCallbackIndex, true)
 			// }
 
-			c.render(currNode.FirstChild, buf, false, markers)
+			if staticState.has(skipContent) {
+				markers.markSkipped(currNode.FirstChild)
+			} else {
+				c.render(currNode.FirstChild, buf, false, markers)
+			}
 
 			set_marker_pos(newMarker, postCallbackIndex, false)
 
+			// The second half of a wrapper that a Static callback inserted goes
+			// outside the runtime output of the same position
+			if postEnding != nil {
+				postEnding()
+			}
+
 			if c.canElideCloser(currNode) {
 
 				// If whitespace compression is enabled and
@@ -544,24 +912,26 @@ func (c *component[T]) render(
 					}
 				}
 
-			} else {
+			} else if !isEmptyElement(currNode.DataAtom) { // Void elements never get a closer
 				fmt.Fprintf(buf, "</%s>", currNode.Data)
 			}
 
 			set_marker_pos(newMarker, preCallbackIndex, false)
+
+			if preEnding != nil {
+				preEnding()
+			}
 		}
 	}
-
-	return
 }
 
-// If 'data-freak' attribute is found, it check that its name is the same as in the next Marker provided
-// by the user. It also creates a new *marker for each Marker/position combination, and adds them to the
-// component[T]. The user-defined Marker is removed from the original slice. Each added *marker is also
-// returned in its own "position" based slice, so that the proper ending position can be added to each.
+// If 'data-freak' attribute is found, it finds the Marker of that name provided by the user. It
+// creates a new *marker for the element, which is added to the component[T], and returned so that
+// the positions of its callbacks can be set. The same Marker may be used by several elements. The
+// Marker's Static callbacks are returned in the order of pre, attrs and post, to be run right away.
 func (c *component[T]) processFreakAttr(
-	node *html_parser.Node, isTop bool, userMarkers []Marker[T],
-) (*marker[T], []Marker[T]) {
+	node *html_parser.Node, isTop bool, userMarkers userMarkers[T],
+) (newMarker *marker[T], static [3]MarkerCallback[T]) {
 
 	/*
 		User provides:
@@ -575,8 +945,6 @@ func (c *component[T]) processFreakAttr(
 			data-freak=":compID;markerName"
 	*/
 
-	var newMarker *marker[T]
-
 	var foundJS = false
 
 	for i := range node.Attr {
@@ -592,54 +960,44 @@ func (c *component[T]) processFreakAttr(
 		}
 
 		if attr.Key == dataFreakAttr {
-			if len(userMarkers) == 0 || userMarkers[0].Name != attr.Val {
-				panic(fmt.Sprintf("No marker callbacks found for the %q marker", attr.Val))
+			var um = userMarkers[attr.Val]
+			if um == nil {
+				c.compiling.add(
+					c.compiling.attrOffset(node, dataFreakAttr), "no marker callbacks found for the %q marker", attr.Val,
+				)
+				return nil, static
 			}
 
-			var uM = userMarkers[0]
-			userMarkers = userMarkers[1:]
-
-			newMarker = &marker[T]{}
-			c.markers = append(c.markers, newMarker)
-
-			var foundPre, foundAttrs, foundPost bool
+			um.used = true
+			var uM = um.Marker
 
-			for _, pos := range uM.Positions {
-				var cb = callbackPos[T]{
-					callback: pos.do,
-				}
-
-				switch pos.(type) {
-
-				case Pre[T]:
-					if foundPre || foundAttrs || foundPost {
-						goto OUT_OF_ORDER
-					}
-					foundPre = true
-					newMarker.callbacks[preCallbackIndex] = cb
-
-				case Attrs[T]:
-					if foundAttrs || foundPost {
-						goto OUT_OF_ORDER
-					}
-					foundAttrs = true
-					newMarker.callbacks[attrCallbackIndex] = cb
+			callbacks, ok := sortCallbacks(uM.Positions)
+			if !ok {
+				c.compiling.add(
+					c.compiling.attrOffset(node, dataFreakAttr), "positions for the %q marker are out of order", uM.Name,
+				)
+				return nil, static
+			}
 
-				case Post[T]:
-					if foundPost {
-						goto OUT_OF_ORDER
-					}
-					foundPost = true
-					newMarker.callbacks[postCallbackIndex] = cb
+			if static, ok = sortCallbacks(uM.Static); !ok {
+				c.compiling.add(
+					c.compiling.attrOffset(node, dataFreakAttr), "static callbacks for the %q marker are out of order", uM.Name,
+				)
+				return nil, static
+			}
 
-				default:
-					panic("unreachable")
-				}
+			if !hasCallback(callbacks) {
+				// Only Static callbacks, so nothing is left to do at runtime
+				node.Attr = append(node.Attr[:i], node.Attr[i+1:]...)
+				foundJS = foundJS || hasAttr(node.Attr[i:], dataFreakJSAttr)
+				break
+			}
 
-				continue
+			newMarker = &marker[T]{}
+			c.markers = append(c.markers, newMarker)
 
-			OUT_OF_ORDER:
-				panic(fmt.Sprintf("Positions for marker %q are out of order", uM.Name))
+			for i, cb := range callbacks {
+				newMarker.callbacks[i].callback = cb
 			}
 
 			if isTop {
@@ -648,7 +1006,7 @@ func (c *component[T]) processFreakAttr(
 				attr.Val = ":" + c.compId + ";" + attr.Val
 			}
 
-			return newMarker, userMarkers
+			return newMarker, static
 		}
 	}
 
@@ -664,7 +1022,125 @@ func (c *component[T]) processFreakAttr(
 		})
 	}
 
-	return nil, userMarkers
+	return nil, static
+}
+
+// sortCallbacks puts the callbacks of Positions or Static in the order of pre,
+// attrs and post. It reports false if they were given out of that order.
+func sortCallbacks[T any](positions [3]do[T]) (callbacks [3]MarkerCallback[T], ok bool) {
+	var found = -1
+
+	for _, pos := range positions {
+		if isNilPosition(pos) {
+			continue
+		}
+
+		var index int
+
+		switch pos.(type) {
+		case Pre[T]:
+			index = preCallbackIndex
+		case Attrs[T]:
+			index = attrCallbackIndex
+		case Post[T]:
+			index = postCallbackIndex
+		default:
+			panic("unreachable")
+		}
+
+		if index <= found {
+			return callbacks, false
+		}
+		found = index
+
+		callbacks[index] = pos.do
+	}
+
+	return callbacks, true
+}
+
+func hasCallback[T any](callbacks [3]MarkerCallback[T]) bool {
+	for _, cb := range callbacks {
+		if cb != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttr(attrs []html_parser.Attribute, key string) bool {
+	for _, attr := range attrs {
+		if strings.EqualFold(attr.Key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// runStatic runs a Static callback of a marker with the zero value of T, and
+// writes its output into the compiled HTML. The state of the response is
+// returned, to see if the callback skipped the element or its content, along
+// with the ending of a wrapper that the callback inserted, and the error of a
+// method that can't be used at compile time.
+func runStatic[T any](cb MarkerCallback[T], buf *bytes.Buffer) (state[componentStateFlag], func(), error) {
+	if cb == nil {
+		return state[componentStateFlag]{}, nil, nil
+	}
+
+	var r = response[T]{&responseCore{writer: buf}}
+	r.responseState.set(inStatic)
+
+	var zero T
+
+	cb(&r, zero)
+
+	return r.componentState, r.wrapperEndings, r.err
+}
+
+// staticError is given when a Static callback uses a method whose value
+// belongs to one request.
+func staticError(method string) error {
+	return fmt.Errorf("%s can't be used by a Static callback, since its output is shared by every request", method)
+}
+
+// addNonceMarker makes the given marker of a script, style or stylesheet link
+// element add the request's CSP nonce, before any user-defined attributes. A
+// marker is created if the element had none, which is skipped when there's no
+// policy.
+func (c *component[T]) addNonceMarker(m *marker[T]) *marker[T] {
+	if m == nil {
+		m = &marker[T]{nonceOnly: true}
+		c.markers = append(c.markers, m)
+	}
+
+	var userAttrs = m.callbacks[attrCallbackIndex].callback
+
+	m.callbacks[attrCallbackIndex].callback = func(r *response[T], data T) {
+		r.writeNonceAttr()
+
+		if userAttrs != nil {
+			userAttrs(r, data)
+		}
+	}
+
+	return m
+}
+
+// isNilPosition reports if a position was left out, either as a nil interface
+// or as a nil function like `freak.Attrs(nil)`.
+func isNilPosition[T any](pos do[T]) bool {
+	switch p := pos.(type) {
+	case nil:
+		return true
+	case Pre[T]:
+		return p == nil
+	case Attrs[T]:
+		return p == nil
+	case Post[T]:
+		return p == nil
+	default:
+		return false
+	}
 }
 
 func sortAttrs(attrs []html_parser.Attribute) []html_parser.Attribute {
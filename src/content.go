@@ -0,0 +1,129 @@
+package freak
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const _accept = "Accept"
+
+// Content types for the non-HTML writers and for Negotiate.
+const (
+	ContentHTML = _htmlContent
+	ContentJSON = "application/json; charset=utf-8"
+	ContentText = "text/plain; charset=utf-8"
+)
+
+// WriteJSON encodes `v` as the JSON body of the response. Nothing is written
+// if the encoding fails.
+func (r *RouteResponse) WriteJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	r.WriteBytes(ContentJSON, b)
+	return nil
+}
+
+// WriteText writes `s` as a plain text body.
+func (r *RouteResponse) WriteText(s string) {
+	r.WriteBytes(ContentText, strToBytes(s))
+}
+
+// WriteBytes writes `b` as the body of the response, with the given content
+// type. The bytes are still buffered, and gzipped if the client accepts it.
+func (r *RouteResponse) WriteBytes(contentType string, b []byte) {
+	r.r.resp.Header()[_contentType] = []string{contentType}
+	r.r.writer.Write(b)
+}
+
+// Negotiate returns the offered content type that best fits the request's
+// Accept header, or an empty string if none are acceptable. Without an Accept
+// header, the first offer is returned. Offers may be any of the `Content...`
+// constants, or other media types. On a cached route, the Accept header is
+// then part of the cache key.
+func (r *RouteResponse) Negotiate(offers ...string) string {
+	var hdrs = r.r.resp.Header()
+	if !containsFold(hdrs[_vary], _accept) {
+		hdrs.Add(_vary, _accept)
+	}
+
+	return negotiate(r.r.req.Header.Get(_accept), offers)
+}
+
+func negotiate(accept string, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if len(strings.TrimSpace(accept)) == 0 {
+		return offers[0]
+	}
+
+	var best = ""
+	var bestQ = 0.0
+
+	for _, offer := range offers {
+		var offerType = mediaType(offer)
+		var q, specificity = 0.0, -1
+
+		for _, accepted := range strings.Split(accept, ",") {
+			var rangeType, rangeQ = parseAcceptRange(accepted)
+
+			var s = matchMediaRange(rangeType, offerType)
+			if s > specificity {
+				q, specificity = rangeQ, s
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+// mediaType strips the parameters and returns the lower case type/subtype.
+func mediaType(s string) string {
+	if idx := strings.IndexByte(s, ';'); idx != -1 {
+		s = s[0:idx]
+	}
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// parseAcceptRange splits a single Accept entry into its media range and its
+// quality value, which defaults to 1.
+func parseAcceptRange(s string) (string, float64) {
+	var q = 1.0
+	var params = strings.Split(s, ";")
+
+	for _, p := range params[1:] {
+		var key, val, _ = strings.Cut(strings.TrimSpace(p), "=")
+
+		if strings.EqualFold(key, "q") {
+			if parsed, err := strconv.ParseFloat(val, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+
+	return mediaType(params[0]), q
+}
+
+// matchMediaRange returns -1 if the range doesn't cover the type, and
+// otherwise how specific the match was (0 for */*, 1 for type/*, 2 for exact).
+func matchMediaRange(rangeType, offerType string) int {
+	switch {
+	case rangeType == offerType:
+		return 2
+	case rangeType == "*/*":
+		return 0
+	case strings.HasSuffix(rangeType, "/*") &&
+		strings.HasPrefix(offerType, rangeType[0:len(rangeType)-1]):
+		return 1
+	default:
+		return -1
+	}
+}
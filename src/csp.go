@@ -0,0 +1,164 @@
+package freak
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	_csp           = "Content-Security-Policy"
+	_cspReportOnly = "Content-Security-Policy-Report-Only"
+	_cspNonceLen   = 16
+)
+
+// CSPConfig configures the Content-Security-Policy of a Server.
+type CSPConfig struct {
+	// Policy holds the directives, like "default-src 'self'; img-src *". The
+	// nonce of each request is added to its script-src and style-src
+	// directives, which are created if they are missing.
+	Policy string
+
+	// ReportOnly sends the policy in the Content-Security-Policy-Report-Only
+	// header, so that violations are reported but not blocked.
+	ReportOnly bool
+
+	// ReportURI, if set, is added as the report-uri directive.
+	ReportURI string
+}
+
+// SetContentSecurityPolicy enables the CSP header on page responses. A nonce
+// is generated for each request, and is added to every script, style and
+// stylesheet link tag that is rendered by a component or page. Since each
+// page has its own nonce, the output cache of routes is not used.
+func (s *Server) SetContentSecurityPolicy(config CSPConfig) error {
+	return (*server)(s).setContentSecurityPolicy(config)
+}
+
+func (s *server) setContentSecurityPolicy(config CSPConfig) error {
+	if s.isStarted {
+		return fmt.Errorf("Server is already running")
+	}
+
+	var p = contentSecurityPolicy{headerName: _csp}
+
+	if config.ReportOnly {
+		p.headerName = _cspReportOnly
+	}
+
+	var foundScript, foundStyle bool
+
+	for _, directive := range strings.Split(config.Policy, ";") {
+		directive = strings.TrimSpace(directive)
+		if len(directive) == 0 {
+			continue
+		}
+
+		var name, _, _ = strings.Cut(directive, " ")
+		name = strings.ToLower(name)
+
+		switch name {
+		case "script-src":
+			foundScript = true
+		case "style-src":
+			foundStyle = true
+		case "report-uri":
+			if len(config.ReportURI) != 0 {
+				continue // Replaced by the configured one
+			}
+		}
+
+		p.directives = append(p.directives, cspDirective{
+			text:     directive,
+			getNonce: name == "script-src" || name == "style-src",
+		})
+	}
+
+	if !foundScript {
+		p.directives = append(p.directives, cspDirective{text: "script-src", getNonce: true})
+	}
+	if !foundStyle {
+		p.directives = append(p.directives, cspDirective{text: "style-src", getNonce: true})
+	}
+	if len(config.ReportURI) != 0 {
+		p.directives = append(p.directives, cspDirective{text: "report-uri " + config.ReportURI})
+	}
+
+	s.csp = &p
+	return nil
+}
+
+type contentSecurityPolicy struct {
+	headerName string
+	directives []cspDirective
+}
+
+type cspDirective struct {
+	text     string
+	getNonce bool
+}
+
+// header returns the policy with the nonce added.
+func (p *contentSecurityPolicy) header(nonce string) string {
+	var b strings.Builder
+
+	for i, d := range p.directives {
+		if i != 0 {
+			b.WriteString("; ")
+		}
+
+		b.WriteString(d.text)
+
+		if d.getNonce {
+			b.WriteString(" 'nonce-")
+			b.WriteString(nonce)
+			b.WriteByte('\'')
+		}
+	}
+
+	return b.String()
+}
+
+// setNonce creates the nonce for the request, and sends the policy with it.
+// The page is not cached, since a nonce must not be used twice.
+func (s *server) setNonce(r *response[*RouteData]) {
+	if s.csp == nil {
+		return
+	}
+
+	r.nonce = randomString(_cspNonceLen)
+	r.responseState.set(skipCache)
+
+	// Added to any frame-ancestors policy of the security headers
+	r.resp.Header().Add(s.csp.headerName, s.csp.header(r.nonce))
+}
+
+// Nonce returns the CSP nonce of the request, for any script or style tag
+// that is written by hand. It is empty if there's no policy. A Static callback
+// can't use it.
+func (r *response[T]) Nonce() string {
+	if r.responseState.has(inStatic) {
+		r.fail(http.StatusInternalServerError, staticError("Nonce"))
+	}
+	return r.nonce
+}
+
+// Nonce returns the CSP nonce of the request, for any script or style tag
+// that is written by hand. It is empty if there's no policy.
+func (r *RouteResponse) Nonce() string {
+	return r.r.Nonce()
+}
+
+var bytNonceAttr = []byte(` nonce="`)
+
+// writeNonceAttr adds the nonce attribute to a script or style tag.
+func (r *response[T]) writeNonceAttr() {
+	if len(r.nonce) == 0 {
+		return
+	}
+
+	r.writer.Write(bytNonceAttr)
+	io.WriteString(r.writer, r.nonce) // Base64 never needs escaping
+	r.writer.Write(bytQuote)
+}
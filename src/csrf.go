@@ -0,0 +1,196 @@
+package freak
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	_defaultCSRFField  = "freak-csrf"
+	_defaultCSRFHeader = "X-CSRF-Token"
+	_defaultCSRFCookie = "freak-csrf"
+	_csrfSessionKey    = "freak-csrf"
+	_csrfTokenLen      = 32
+	_csrfDataAttr      = "data-csrf-token"
+)
+
+// ErrCSRF is the error given to the 403 page when a request fails the CSRF
+// check.
+var ErrCSRF = errors.New("CSRF token is missing or invalid")
+
+// CSRFConfig configures the CSRF protection of a Server. The zero value uses
+// the defaults.
+type CSRFConfig struct {
+	// FieldName is the form field that holds the token. It defaults to
+	// "freak-csrf".
+	FieldName string
+
+	// HeaderName is checked when the form field is absent, for requests sent
+	// by scripts. It defaults to "X-CSRF-Token".
+	HeaderName string
+
+	// CookieName is used to hold the token when sessions aren't enabled. It
+	// defaults to "freak-csrf".
+	CookieName string
+
+	// Secure is set on the token cookie.
+	Secure bool
+
+	// ErrorPage is rendered for failed checks. If nil, the page that was set
+	// with SetErrorPage for the 403 code is used.
+	ErrorPage ErrorPage
+}
+
+// SetCSRF enables CSRF protection. Each session gets a token, which every
+// POST, PUT, PATCH and DELETE request must send back in a form field or a
+// header. The token is kept in the session if sessions are enabled, and
+// otherwise in a cookie signed with the secret key. Routes can opt out with
+// Route.SkipCSRF.
+func (s *Server) SetCSRF(config CSRFConfig) error {
+	return (*server)(s).setCSRF(config)
+}
+
+func (s *server) setCSRF(config CSRFConfig) error {
+	if s.isStarted {
+		return fmt.Errorf("Server is already running")
+	}
+	if s.secret == nil {
+		return fmt.Errorf("SetSecretKey must be called before SetCSRF")
+	}
+
+	if len(config.FieldName) == 0 {
+		config.FieldName = _defaultCSRFField
+	}
+	if len(config.HeaderName) == 0 {
+		config.HeaderName = _defaultCSRFHeader
+	}
+	if len(config.CookieName) == 0 {
+		config.CookieName = _defaultCSRFCookie
+	}
+
+	s.csrf = &config
+	return nil
+}
+
+// isUnsafeMethod reports if the method may change state on the server, and
+// so needs the CSRF check.
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	default:
+		return true
+	}
+}
+
+// checkCSRF reports if the request may proceed. Safe methods always pass.
+func (s *server) checkCSRF(r *response[*RouteData], fh *freakHandler) bool {
+	if s.csrf == nil || fh.route.SkipCSRF || !isUnsafeMethod(r.req.Method) {
+		return true
+	}
+
+	var expected = r.existingCSRFToken()
+	if len(expected) == 0 {
+		return false
+	}
+
+	var got = r.req.Header.Get(s.csrf.HeaderName)
+	if len(got) == 0 {
+		got = r.req.PostFormValue(s.csrf.FieldName)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(got), []byte(expected)) == 1
+}
+
+// CSRFToken returns the token that forms must send back. One is created if
+// the session doesn't have one yet. It is empty if CSRF protection is not
+// enabled. Since the token belongs to the user, the page is not cached, and a
+// Static callback can't use it.
+func (r *response[T]) CSRFToken() string {
+	if r.responseState.has(inStatic) {
+		r.fail(http.StatusInternalServerError, staticError("CSRFToken"))
+		return ""
+	}
+
+	if r.server == nil || r.server.csrf == nil {
+		return ""
+	}
+
+	r.responseState.set(skipCache)
+
+	if len(r.csrfToken) != 0 {
+		return r.csrfToken
+	}
+
+	if r.csrfToken = r.existingCSRFToken(); len(r.csrfToken) != 0 {
+		return r.csrfToken
+	}
+
+	r.csrfToken = randomString(_csrfTokenLen)
+
+	if sess := r.getSession(); sess != nil {
+		sess.Set(_csrfSessionKey, r.csrfToken)
+
+	} else {
+		var config = r.server.csrf
+
+		r.cookiesToSend = append(r.cookiesToSend, &http.Cookie{
+			Name:     config.CookieName,
+			Value:    r.server.secret.sign(config.CookieName, r.csrfToken),
+			Path:     "/",
+			Secure:   config.Secure,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	return r.csrfToken
+}
+
+// existingCSRFToken returns the token from the session or the cookie, if the
+// user already has one.
+func (r *response[T]) existingCSRFToken() string {
+	if sess := r.getSession(); sess != nil {
+		return sess.Get(_csrfSessionKey)
+	}
+
+	if c := r.getCookie(r.server.csrf.CookieName); c != nil {
+		var token, _ = r.server.secret.verify(r.server.csrf.CookieName, c.Value)
+		return token
+	}
+
+	return ""
+}
+
+// WriteCSRFInput writes the hidden form input that holds the CSRF token.
+// Nothing is written if CSRF protection is not enabled.
+func (r *response[T]) WriteCSRFInput() {
+	var token = r.CSRFToken()
+	if len(token) == 0 {
+		return
+	}
+
+	io.WriteString(r.writer, `<input type=hidden name="`)
+	writeEscapeHTMLString(r.writer, r.server.csrf.FieldName)
+	io.WriteString(r.writer, `" value="`)
+	writeEscapeHTMLString(r.writer, token)
+	io.WriteString(r.writer, `">`)
+}
+
+// AddCSRFAttr adds a `data-csrf-token` attribute holding the CSRF token, for
+// scripts to send in the header. Nothing is added if CSRF protection is not
+// enabled.
+func (r AttrResponse[T]) AddCSRFAttr() {
+	if token := r.r.CSRFToken(); len(token) != 0 {
+		r.AddAttr(_csrfDataAttr, token)
+	}
+}
+
+// CSRFToken returns the token that forms must send back. It is empty if CSRF
+// protection is not enabled.
+func (r *RouteResponse) CSRFToken() string {
+	return r.r.CSRFToken()
+}
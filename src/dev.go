@@ -0,0 +1,326 @@
+package freak
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	_devReloadPath          = "/freak-dev-reload"
+	_defaultDevPollInterval = 500 * time.Millisecond
+)
+
+// The script that is added to the aggregated JS in dev mode.
+const devReloadScript = `(function(){new EventSource("` + _devReloadPath +
+	`").addEventListener("reload",function(){location.reload()})})();`
+
+// DevMode configures the development mode of a Server.
+type DevMode struct {
+	// PollInterval is how often the watched files are checked for changes. It
+	// defaults to half a second. The files are watched once for the process,
+	// at the interval of the first server that starts in dev mode.
+	PollInterval time.Duration
+}
+
+// SetDevMode watches the files of the components that were created with
+// HTMLFS, CSSFS or JSFS, or with HTMLFile, CSSFile or JSFile given an
+// *os.File. When one changes, its components are compiled again in place, the
+// aggregated CSS and JS are written again, the output caches are cleared, and
+// open pages are told to reload. It is meant for development only.
+func (s *Server) SetDevMode(dev DevMode) error {
+	return (*server)(s).setDevMode(dev)
+}
+
+func (s *server) setDevMode(dev DevMode) error {
+	if s.isStarted {
+		return fmt.Errorf("Server is already running")
+	}
+
+	if dev.PollInterval <= 0 {
+		dev.PollInterval = _defaultDevPollInterval
+	}
+
+	s.dev = &devServer{
+		interval: dev.PollInterval,
+		clients:  map[chan struct{}]struct{}{},
+	}
+
+	atomic.StoreInt32(&devOn, 1)
+
+	return s.writeCssAndJs() // Now with the reload script
+}
+
+// Components are only replaced while no page is rendered. Since components
+// are shared by every server, so is the lock, and once any server of the
+// process is in dev mode, every render takes it.
+var devMux sync.RWMutex
+var devOn int32
+
+// lockRender takes the render lock if dev mode is on, and reports if it did,
+// for unlockRender.
+func lockRender() bool {
+	if atomic.LoadInt32(&devOn) == 0 {
+		return false
+	}
+	devMux.RLock()
+	return true
+}
+
+func unlockRender(locked bool) {
+	if locked {
+		devMux.RUnlock()
+	}
+}
+
+// fileSource returns the source of a file that was opened from the operating
+// system, like with os.Open, so that it can be watched in dev mode. Other
+// files can't be opened again by name, and give `nil`.
+func fileSource(f fs.File) *source {
+	named, ok := f.(interface{ Name() string })
+	if !ok {
+		return nil
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return nil
+	}
+
+	pth, err := filepath.Abs(named.Name())
+	if err != nil {
+		return nil
+	}
+
+	return &source{
+		fsys:    os.DirFS(filepath.Dir(pth)),
+		name:    filepath.Base(pth),
+		modTime: info.ModTime(),
+	}
+}
+
+// source is a file that a component was read from.
+type source struct {
+	fsys    fs.FS
+	name    string
+	modTime time.Time
+}
+
+func (src *source) read() (string, error) {
+	f, err := src.fsys.Open(src.name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	src.modTime = info.ModTime()
+
+	return fileToString(f), nil
+}
+
+func (src *source) mustRead() string {
+	s, err := src.read()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// changed reports if the file was modified since it was read.
+func (src *source) changed() bool {
+	info, err := fs.Stat(src.fsys, src.name)
+	return err == nil && !info.ModTime().Equal(src.modTime)
+}
+
+// reloadable is a component that was read from files.
+type reloadable struct {
+	sources []*source
+	reload  func() error
+}
+
+var reloadables []*reloadable
+var reloadMux sync.Mutex
+
+// devServers are the started servers in dev mode. The components are shared,
+// so one watcher reloads them, and then tells every server.
+var devServers []*server
+
+// addReloadable records how to compile the component again from its files.
+func addReloadable[T any](c *component[T], css css, js js, h *html, markers []Marker[T]) {
+	var r = reloadable{}
+
+	for _, src := range []*source{css.src, js.src, h.src} {
+		if src != nil {
+			r.sources = append(r.sources, src)
+		}
+	}
+
+	var in = h.in
+
+	r.reload = func() (err error) {
+		if css.src != nil {
+			if css.css, err = css.src.read(); err != nil {
+				return err
+			}
+		}
+		if js.src != nil {
+			if js.js, err = js.src.read(); err != nil {
+				return err
+			}
+		}
+		if h.src != nil {
+			if in, err = h.src.read(); err != nil {
+				return err
+			}
+		}
+
+		// The id is kept, so that the data-freak attributes stay the same
+		var fresh = component[T]{
+			compId:                    c.compId,
+			wrapperContentMarkerIndex: -1,
+		}
+
+		if err = fresh.processHTML(h.name, in, h.level, markers); err != nil {
+			return err // The previous version stays
+		}
+
+		*c = fresh
+		addToCssJs(c.compId, css, js)
+
+		return nil
+	}
+
+	reloadMux.Lock()
+	reloadables = append(reloadables, &r)
+	reloadMux.Unlock()
+}
+
+type devServer struct {
+	interval time.Duration
+
+	mux     sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+// addDevServer adds the server to those told of reloads. It reports if it's
+// the first, which starts the watcher.
+func (s *server) addDevServer() bool {
+	reloadMux.Lock()
+	defer reloadMux.Unlock()
+
+	devServers = append(devServers, s)
+	return len(devServers) == 1
+}
+
+// watch polls the files of the components for as long as the process runs.
+func watch(interval time.Duration) {
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reloadChanged()
+	}
+}
+
+// reloadChanged compiles again the components whose files changed, and tells
+// the servers in dev mode.
+func reloadChanged() {
+	reloadMux.Lock()
+	var list = append([]*reloadable(nil), reloadables...)
+	var servers = append([]*server(nil), devServers...)
+	reloadMux.Unlock()
+
+	var changed []*reloadable
+
+	for _, r := range list {
+		for _, src := range r.sources {
+			if src.changed() {
+				changed = append(changed, r)
+				break
+			}
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	devMux.Lock()
+	for _, r := range changed {
+		if err := r.reload(); err != nil {
+			fmt.Println(err)
+		}
+	}
+	devMux.Unlock()
+
+	for _, s := range servers {
+		if err := s.writeCssAndJs(); err != nil {
+			fmt.Println(err)
+		}
+
+		s.clearCaches()
+		s.dev.broadcast()
+	}
+
+	fmt.Printf("Reloaded %d component(s)\n", len(changed))
+}
+
+// serveEvents holds an event stream open, and sends a reload event when
+// components were reloaded.
+func (d *devServer) serveEvents(resp http.ResponseWriter, req *http.Request) {
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		http.Error(resp, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var ch = make(chan struct{}, 1)
+
+	d.mux.Lock()
+	d.clients[ch] = struct{}{}
+	d.mux.Unlock()
+
+	defer func() {
+		d.mux.Lock()
+		delete(d.clients, ch)
+		d.mux.Unlock()
+	}()
+
+	var hdrs = resp.Header()
+	hdrs[_contentType] = []string{"text/event-stream"}
+	hdrs["Cache-Control"] = []string{"no-cache"}
+
+	resp.WriteHeader(http.StatusOK)
+	io.WriteString(resp, ": connected\n\n")
+	flusher.Flush()
+
+	select {
+	case <-ch:
+		io.WriteString(resp, "event: reload\ndata: \n\n")
+		flusher.Flush()
+
+	case <-req.Context().Done():
+	}
+}
+
+func (d *devServer) broadcast() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	for ch := range d.clients {
+		select {
+		case ch <- struct{}{}:
+		default: // Already told
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package freak
+
+// ListItem is the place in its list of the item that InsertEach renders.
+type ListItem struct {
+	Index       int
+	First, Last bool
+}
+
+// Item returns the place of the item that InsertEach is rendering. Outside of
+// a list, it's the zero ListItem.
+func (r *response[T]) Item() ListItem {
+	return r.item
+}
+
+// InsertEach renders the component once for each of the items. If there are
+// none, `empty` is called instead, when it's not nil. Rendering an item does
+// not allocate, unless its component is a wrapper.
+func InsertEach[P, C any](r *response[P], c Component[C], items []C, empty func()) {
+	if len(items) == 0 {
+		if empty != nil {
+			empty()
+		}
+		return
+	}
+
+	var outer = r.item // A list may be inside the item of another list
+	var last = len(items) - 1
+
+	for i := range items {
+		if r.responseState.hasAny(sent | failed) {
+			break
+		}
+
+		r.item = ListItem{Index: i, First: i == 0, Last: i == last}
+		InsertChild(r, c, items[i])
+	}
+
+	r.item = outer
+}
+
+// InsertSeq is InsertEach for an iterator that yields the items. One item is
+// read ahead, so that the last one is known.
+func InsertSeq[P, C any](r *response[P], c Component[C], seq func(yield func(C) bool), empty func()) {
+	var outer = r.item
+	var prev C
+	var count = 0
+
+	seq(func(item C) bool {
+		if count != 0 {
+			r.item = ListItem{Index: count - 1, First: count == 1}
+			InsertChild(r, c, prev)
+
+			if r.responseState.hasAny(sent | failed) {
+				return false
+			}
+		}
+
+		prev = item
+		count++
+		return true
+	})
+
+	switch {
+	case count == 0:
+		if empty != nil {
+			empty()
+		}
+
+	case !r.responseState.hasAny(sent | failed):
+		r.item = ListItem{Index: count - 1, First: count == 1, Last: true}
+		InsertChild(r, c, prev)
+	}
+
+	r.item = outer
+}
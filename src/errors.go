@@ -0,0 +1,136 @@
+package freak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorPage renders the page for an HTTP error. The `err` is the error that
+// was given to RouteResponse.SendError, and may be `nil`.
+type ErrorPage func(r *RouteResponse, err error)
+
+// StatusError is an error that chooses the status code of the error page,
+// when it's returned by the loader of Page.Route or Component.Route.
+type StatusError struct {
+	Code int
+	Err  error
+}
+
+func (e *StatusError) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// errorStatus returns the code of a StatusError in the chain of `err`, or 500.
+func errorStatus(err error) int {
+	var se *StatusError
+	if errors.As(err, &se) && se.Code >= 400 && se.Code <= 599 {
+		return se.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// SetErrorPage sets the page that is rendered for responses that fail with
+// the given status `code`. Codes without a page get a plain text response.
+func (s *Server) SetErrorPage(code int, page ErrorPage) error {
+	return (*server)(s).setErrorPage(code, page)
+}
+
+func (s *server) setErrorPage(code int, page ErrorPage) error {
+	if s.isStarted {
+		return fmt.Errorf("Server is already running")
+	}
+	if code < 400 || code > 599 {
+		return fmt.Errorf("%d is not an HTTP error code", code)
+	}
+
+	if s.errorPages == nil {
+		s.errorPages = map[int]ErrorPage{}
+	}
+	s.errorPages[code] = page
+
+	return nil
+}
+
+// sendError discards the rendered content of a failed response, and sends
+// the error page in its place. If the client is gone, nothing is sent.
+func (s *server) sendError(r *responseBase[*RouteData]) {
+	if r.responseState.has(sent) {
+		return
+	}
+
+	var code, err = r.status, r.err
+
+	if errors.Is(err, context.Canceled) {
+		r.responseState.set(sent)
+		return
+	}
+
+	if err != nil && code >= 500 {
+		fmt.Println(err)
+	}
+
+	r.resetBody()
+
+	var page = s.errorPages[code]
+
+	if err == ErrCSRF && s.csrf.ErrorPage != nil {
+		page = s.csrf.ErrorPage
+	}
+
+	if page != nil {
+		r.resp.Header()[_contentType] = htmlContentHeader
+
+		// The page gets a fresh context, since the request's may have run out
+		r.setContext(context.Background())
+
+		page(&RouteResponse{r: &r.response}, err)
+
+		if r.responseState.has(sent) {
+			return // The page chose its own response, like a redirect
+		}
+	}
+
+	if page == nil || r.responseState.has(failed) { // No page, or the page failed too
+		r.resetBody()
+		r.resp.Header()[_contentType] = []string{ContentText}
+		r.writer.Write(strToBytes(http.StatusText(code)))
+	}
+
+	r.status = code
+	r.send(NoETag)
+}
+
+// resetBody discards everything written, and clears the failure so that the
+// error page can be rendered.
+func (r *responseBase[T]) resetBody() {
+	r.buf.Reset()
+
+	if r.responseState.has(acceptsGzip) {
+		r.gzip.Reset(&r.buf)
+	}
+
+	r.responseState.unset(failed)
+}
+
+// serveError sends the error page for a request that was refused before it
+// reached its route.
+func (s *server) serveError(resp http.ResponseWriter, req *http.Request, code int, err error) {
+	resp.Header()[_contentType] = htmlContentHeader
+
+	var r = getResponse(s, resp, req, nil, false)
+	defer putResponse(s, r)
+
+	defer unlockRender(lockRender())
+
+	r.fail(code, err)
+	s.sendError(r)
+}
@@ -0,0 +1,69 @@
+package freak
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// ETag selects whether a Route tags its rendered pages, and with which kind
+// of validator.
+type ETag uint8
+
+const (
+	// NoETag sends the page without a tag. This is the default.
+	NoETag = ETag(iota)
+
+	// WeakETag sends a `W/` prefixed tag, which only promises that the page
+	// is semantically equivalent.
+	WeakETag
+
+	// StrongETag sends a tag that promises a byte-for-byte identical page.
+	StrongETag
+)
+
+// tag hashes the final (possibly gzipped) body into a quoted entity tag.
+func (e ETag) tag(body []byte) string {
+	var sum = sha1.Sum(body)
+	var encoded = base64.RawURLEncoding.EncodeToString(sum[:])
+
+	if e == WeakETag {
+		return `W/"` + encoded + `"`
+	}
+	return `"` + encoded + `"`
+}
+
+// eTagMatches reports if any of the tags in an If-None-Match header value
+// matches `tag`. The weak comparison is used, as required for If-None-Match.
+func eTagMatches(ifNoneMatch, tag string) bool {
+	if len(ifNoneMatch) == 0 {
+		return false
+	}
+
+	tag = strings.TrimPrefix(tag, "W/")
+
+	for len(ifNoneMatch) != 0 {
+		var candidate string
+
+		if idx := strings.IndexByte(ifNoneMatch, ','); idx == -1 {
+			candidate, ifNoneMatch = ifNoneMatch, ""
+		} else {
+			candidate, ifNoneMatch = ifNoneMatch[0:idx], ifNoneMatch[idx+1:]
+		}
+
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSafeMethod reports if conditional requests with this method may be
+// answered with a 304.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
@@ -0,0 +1,268 @@
+package freak
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const _sitemapXMLNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// ExportConfig configures a static export of a Server.
+type ExportConfig struct {
+	// Dir is the directory that the site is written to. It is created if it
+	// doesn't exist.
+	Dir string
+
+	// BaseURL is the URL that the site will be hosted at, like
+	// "https://example.com". It is the Host of the requests, and the base of
+	// the URLs in the sitemap.xml.
+	BaseURL string
+
+	// Gzip writes a precompressed .gz sibling of every file that can be
+	// compressed.
+	Gzip bool
+}
+
+// ExportResult lists the pages of an Export.
+type ExportResult struct {
+	// Pages are the paths of the pages that were written.
+	Pages []string
+
+	// Skipped holds the status code of each path that didn't respond with a
+	// 200, and so was not written.
+	Skipped map[string]int
+}
+
+// Export renders every route with a GET request, and writes the pages as a
+// tree of index.html files, with the aggregated CSS and JS, the other files of
+// /res/, favicon.ico and robots.txt, and a sitemap. The result can be served
+// by any static host. The pages are visited in the order of the SiteMapNode
+// tree, whose nodes are the paths of the routes.
+//
+// The pages are rendered once, so a server that has a Content Security
+// Policy or CSRF protection can't be exported, since each page would hold
+// the nonce or token of a single request.
+func (s *Server) Export(config ExportConfig) (ExportResult, error) {
+	return (*server)(s).export(config)
+}
+
+func (s *server) export(config ExportConfig) (result ExportResult, err error) {
+	if len(config.Dir) == 0 {
+		return result, fmt.Errorf("ExportConfig needs a Dir")
+	}
+	if len(config.BaseURL) == 0 {
+		return result, fmt.Errorf("ExportConfig needs a BaseURL for the sitemap.xml")
+	}
+	if s.csp != nil {
+		return result, fmt.Errorf("a server with a Content Security Policy can't be exported")
+	}
+	if s.csrf != nil {
+		return result, fmt.Errorf("a server with CSRF protection can't be exported")
+	}
+
+	base, err := url.Parse(config.BaseURL)
+	if err != nil {
+		return result, err
+	}
+	if len(base.Host) == 0 {
+		return result, fmt.Errorf("ExportConfig.BaseURL has no host: %q", config.BaseURL)
+	}
+
+	var ex = exporter{dir: config.Dir, gzip: config.Gzip}
+
+	for _, fh := range s.exportRoutes() {
+		var pth = fh.route.Path
+
+		var req = httptest.NewRequest(http.MethodGet, pth, nil)
+		req.Host = base.Host
+
+		// Served past the per client rate limit, which all these would share
+		var rec = httptest.NewRecorder()
+		s.security.apply(s, rec, req)
+		s.serve(rec, req, pth, -1, fh, false)
+
+		if rec.Code != http.StatusOK {
+			if result.Skipped == nil {
+				result.Skipped = map[string]int{}
+			}
+			result.Skipped[pth] = rec.Code
+			continue
+		}
+
+		if err = ex.write(path.Join(pth, "index.html"), rec.Body.Bytes()); err != nil {
+			return result, err
+		}
+		result.Pages = append(result.Pages, pth)
+	}
+
+	if err = ex.copyDir(filepath.Join(s.binaryPath, _res_dir_name), _res_dir_name); err != nil {
+		return result, err
+	}
+
+	for _, name := range []string{"favicon.ico", "robots.txt"} {
+		if err = ex.copyFile(filepath.Join(s.binaryPath, name), name); err != nil && !os.IsNotExist(err) {
+			return result, err
+		}
+	}
+
+	return result, ex.write("sitemap.xml", sitemapXML(base, result.Pages))
+}
+
+// exportRoutes returns each route that can be rendered. They're in the order
+// of the SiteMapNode tree, parents first, followed by any that aren't in it,
+// sorted by path.
+func (s *server) exportRoutes() []*freakHandler {
+	var byNode = map[*SiteMapNode]*freakHandler{}
+	var rest []*freakHandler
+
+	for _, fh := range s.routes {
+		if len(fh.staticFilePath) != 0 || fh.route.Handler == nil {
+			continue
+		}
+
+		if fh.siteMapNode == nil {
+			rest = append(rest, fh)
+		} else {
+			byNode[fh.siteMapNode] = fh
+		}
+	}
+
+	var seen = map[*freakHandler]bool{}
+	var routes []*freakHandler
+
+	var add = func(fh *freakHandler) {
+		if fh != nil && !seen[fh] {
+			seen[fh] = true
+			routes = append(routes, fh)
+		}
+	}
+
+	// The tree is shared by every server, so only this server's nodes are used
+	var walk func(n *SiteMapNode)
+	walk = func(n *SiteMapNode) {
+		add(byNode[n])
+		delete(byNode, n)
+
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(&rootPage)
+
+	for _, fh := range byNode { // Nodes that are no longer in the tree
+		rest = append(rest, fh)
+	}
+
+	sort.Slice(rest, func(i, j int) bool {
+		return rest[i].route.Path < rest[j].route.Path
+	})
+
+	for _, fh := range rest {
+		add(fh)
+	}
+	return routes
+}
+
+// sitemapXML lists the pages with their absolute URLs.
+func sitemapXML(base *url.URL, paths []string) []byte {
+	var b strings.Builder
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<urlset xmlns="` + _sitemapXMLNS + `">`)
+
+	for _, pth := range paths {
+		var loc = *base
+		loc.Path = strings.TrimSuffix(base.Path, "/") + pth
+
+		b.WriteString("<url><loc>")
+		xml.EscapeText(&b, []byte(loc.String()))
+		b.WriteString("</loc></url>")
+	}
+
+	b.WriteString("</urlset>\n")
+	return []byte(b.String())
+}
+
+type exporter struct {
+	dir  string
+	gzip bool
+}
+
+// write writes the file at the slash separated `name`, and its .gz sibling.
+func (ex exporter) write(name string, data []byte) error {
+	var full = filepath.Join(ex.dir, filepath.FromSlash(name))
+
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(full, data, 0o644); err != nil {
+		return err
+	}
+
+	if !ex.gzip || !fileExt[path.Ext(name)].canGzip {
+		return nil
+	}
+
+	f, err := os.Create(full + ".gz")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, _ := gzip.NewWriterLevel(f, gzip.BestCompression) // The level is valid
+	if _, err = gz.Write(data); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (ex exporter) copyFile(src, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return ex.write(name, data)
+}
+
+// copyDir copies the directory's files under the slash separated `name`.
+// Precompressed files are left out, since they are made again if wanted.
+func (ex exporter) copyDir(src, name string) error {
+	return filepath.WalkDir(src, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && pth == src {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(pth, ".gz") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, pth)
+		if err != nil {
+			return err
+		}
+		return ex.copyFile(pth, path.Join(name, filepath.ToSlash(rel)))
+	})
+}
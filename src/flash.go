@@ -0,0 +1,128 @@
+package freak
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	_flashCookie     = "freak-flash"
+	_flashSessionKey = "freak-flash"
+	_flashMaxAge     = 60 // seconds; only needs to survive a redirect
+)
+
+// FlashMessage is a message carried over to the next request, usually across
+// the redirect of the Post/Redirect/Get pattern.
+type FlashMessage struct {
+	Kind    string
+	Message string
+}
+
+type flashState struct {
+	out, in []FlashMessage
+	read    bool
+}
+
+// Flash adds a message for the next request to read with Flashes. When
+// sessions are enabled it is stored in the session, and otherwise in a short
+// lived cookie that is signed with the secret key.
+func (r *RouteResponse) Flash(kind, msg string) {
+	r.r.flash.out = append(r.r.flash.out, FlashMessage{Kind: kind, Message: msg})
+}
+
+// Flashes returns the messages that were flashed by the previous request.
+// They are consumed, so the request after this one won't see them.
+func (r *RouteResponse) Flashes() []FlashMessage {
+	return r.r.Flashes()
+}
+
+// Flashes returns the messages that were flashed by the previous request.
+// They are consumed, so the request after this one won't see them.
+func (r *response[T]) Flashes() []FlashMessage {
+	if !r.flash.read {
+		r.flash.read = true
+		r.flash.in = r.readFlashes()
+	}
+	return r.flash.in
+}
+
+func (r *response[T]) readFlashes() []FlashMessage {
+	var encoded string
+
+	if sess := r.getSession(); sess != nil {
+		encoded = sess.Get(_flashSessionKey)
+
+	} else if c := r.getCookie(_flashCookie); c != nil && r.server.secret != nil {
+		encoded, _ = r.server.secret.verify(_flashCookie, c.Value)
+	}
+
+	if len(encoded) == 0 {
+		return nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+
+	var flashes []FlashMessage
+	json.Unmarshal(b, &flashes)
+
+	return flashes
+}
+
+// commitFlashes stores the new messages, and removes those that were read.
+// It must be called before the session is committed.
+func (r *response[T]) commitFlashes() {
+	var consumed = r.flash.read && len(r.flash.in) != 0
+
+	if len(r.flash.out) == 0 && !consumed {
+		return
+	}
+
+	defer func() { // So that repeated commits are a no-op
+		r.flash.out, r.flash.in = r.flash.out[0:0], nil
+	}()
+
+	var encoded string
+
+	if len(r.flash.out) != 0 {
+		b, err := json.Marshal(r.flash.out)
+		if err != nil {
+			panic(err) // unreachable; only strings are marshaled
+		}
+		encoded = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	if sess := r.getSession(); sess != nil {
+		if len(encoded) != 0 {
+			sess.Set(_flashSessionKey, encoded)
+		} else {
+			sess.Delete(_flashSessionKey)
+		}
+		return
+	}
+
+	var c = http.Cookie{
+		Name:     _flashCookie,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if len(encoded) == 0 {
+		c.MaxAge = -1
+
+	} else if r.server == nil || r.server.secret == nil {
+		fmt.Println("Flash messages need either sessions or SetSecretKey")
+		return
+
+	} else {
+		c.Value = r.server.secret.sign(_flashCookie, encoded)
+		c.MaxAge = _flashMaxAge
+	}
+
+	r.cookiesToSend = append(r.cookiesToSend, &c)
+}
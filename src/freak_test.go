@@ -1,12 +1,24 @@
 package freak
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 type tt testing.T
@@ -97,3 +109,1860 @@ func toInterfaces(vals []reflect.Value) []interface{} {
 	}
 	return itfs
 }
+
+func TestETagMatches(t *testing.T) {
+	testResult(t, eTagMatches, true).
+		with(`"abc"`, `"abc"`).
+		with(`W/"abc"`, `"abc"`).
+		with(`"abc"`, `W/"abc"`).
+		with(`"xyz", "abc"`, `"abc"`).
+		with(`*`, `"abc"`).
+		run()
+
+	testResult(t, eTagMatches, false).
+		with(``, `"abc"`).
+		with(`"xyz"`, `"abc"`).
+		with(`"abcd", "ab"`, `"abc"`).
+		run()
+}
+
+func TestETagRequest(t *testing.T) {
+	var s = newTestServer(t, Route{
+		RouteData: RouteData{Path: "/"},
+		ETag:      StrongETag,
+		Handler: func(r *RouteResponse, _ *RouteData) {
+			r.WriteText("tagged")
+		},
+	})
+
+	var rec = serveTestRequest(s, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var tag = rec.Header().Get(_eTag)
+	if rec.Code != http.StatusOK || len(tag) == 0 || rec.Body.String() != "tagged" {
+		t.Fatalf("got: %d, %q, %q", rec.Code, tag, rec.Body.String())
+	}
+
+	for ifNoneMatch, want := range map[string]int{
+		tag:           http.StatusNotModified,
+		`"other"`:     http.StatusOK,
+		"W/" + tag:    http.StatusNotModified,
+		`"a", ` + tag: http.StatusNotModified,
+	} {
+		var req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(_ifNoneMatch, ifNoneMatch)
+
+		var rec = serveTestRequest(s, req)
+
+		if rec.Code != want || rec.Header().Get(_eTag) != tag {
+			t.Errorf("%s: want: %d, got: %d, %q", ifNoneMatch, want, rec.Code, rec.Header().Get(_eTag))
+		}
+		if want == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Errorf("%s: 304 with a body: %q", ifNoneMatch, rec.Body.String())
+		}
+		if want == http.StatusOK && rec.Body.String() != "tagged" {
+			t.Errorf("%s: got: %q", ifNoneMatch, rec.Body.String())
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	var offers = []string{ContentHTML, ContentJSON}
+
+	testResult(t, negotiate, ContentHTML).
+		with("", offers).
+		with("text/html", offers).
+		with("text/*, application/json;q=0.5", offers).
+		with("*/*", offers).
+		run()
+
+	testResult(t, negotiate, ContentJSON).
+		with("application/json", offers).
+		with("text/html;q=0.4, application/*", offers).
+		with("text/html;q=0, */*;q=0.1", offers).
+		run()
+
+	testResult(t, negotiate, "").
+		with("image/png", offers).
+		run()
+}
+
+func TestCacheKey(t *testing.T) {
+	var c = newOutputCache(Cache{KeyHeaders: []string{"x-lang"}, KeyCookies: []string{"theme"}}, true)
+
+	var key = func(target, lang, theme string) string {
+		var req = httptest.NewRequest(http.MethodGet, target, nil)
+		if lang != "" {
+			req.Header.Set("X-Lang", lang)
+		}
+		if theme != "" {
+			req.AddCookie(&http.Cookie{Name: "theme", Value: theme})
+		}
+		return c.key(req)
+	}
+
+	testResult(t, key, "/a?x=1\x00en\x00dark").
+		with("/a?x=1", "en", "dark").
+		run()
+
+	testResult(t, key, "/a?\x00\x00").
+		with("/a", "", "").
+		run()
+
+	if key("/a", "en", "") == key("/a", "", "en") {
+		t.Errorf("a header and a cookie gave the same key")
+	}
+
+	if want := []string{_acceptEncoding, "X-Lang", "Cookie"}; !reflect.DeepEqual(c.vary, want) {
+		t.Errorf("vary: want: %q, got: %q", want, c.vary)
+	}
+
+	var plain = newOutputCache(Cache{}, false)
+	if k := plain.key(httptest.NewRequest(http.MethodGet, "/a?x=1", nil)); k != "/a?x=1" {
+		t.Errorf("got: %q", k)
+	}
+	if k := plain.key(httptest.NewRequest(http.MethodGet, "/a", nil)); k != "/a" {
+		t.Errorf("got: %q", k)
+	}
+
+	var ignore = newOutputCache(Cache{IgnoreQuery: true}, false)
+	if k := ignore.key(httptest.NewRequest(http.MethodGet, "/a?x=1", nil)); k != "/a" {
+		t.Errorf("IgnoreQuery: got: %q", k)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	var c = newOutputCache(Cache{TTL: time.Minute, StaleWhileRevalidate: time.Minute}, false)
+	var now = time.Now()
+
+	var e = &cacheEntry{}
+	c.put("/", e, now)
+
+	for _, test := range []struct {
+		after        time.Duration
+		found, fresh bool
+	}{
+		{0, true, true},
+		{time.Minute - 1, true, true},
+		{time.Minute, true, false},
+		{2*time.Minute - 1, true, false},
+		{2 * time.Minute, false, false},
+	} {
+		got, fresh := c.get("/", now.Add(test.after))
+
+		if (got == e) != test.found || fresh != test.fresh {
+			t.Errorf("after %s: want: %t, %t, got: %t, %t", test.after, test.found, test.fresh, got == e, fresh)
+		}
+	}
+
+	// The expired entry is swept when another is stored
+	c.put("/other", &cacheEntry{}, now.Add(3*time.Minute))
+
+	if _, ok := c.entries["/"]; ok {
+		t.Errorf("the expired entry was not swept")
+	}
+}
+
+func TestCacheRoute(t *testing.T) {
+	var renders int32
+
+	var s = newTestServer(t, Route{
+		RouteData: RouteData{Path: "/"},
+		Cache:     &Cache{TTL: time.Hour, Tags: []string{"route"}},
+		Handler: func(r *RouteResponse, _ *RouteData) {
+			r.CacheTags(r.Query("tag"))
+			r.WriteText(strconv.Itoa(int(atomic.AddInt32(&renders, 1))))
+		},
+	})
+
+	var get = func(method, target string) string {
+		return serveTestRequest(s, httptest.NewRequest(method, target, nil)).Body.String()
+	}
+
+	for i, test := range []struct {
+		method, target, want string
+		invalidate           []string
+	}{
+		{http.MethodGet, "/", "1", nil},
+		{http.MethodGet, "/", "1", nil},
+		{http.MethodGet, "/?page=2", "2", nil}, // The query is in the key
+		{http.MethodGet, "/?page=2", "2", nil},
+		{http.MethodPost, "/", "3", nil}, // Never cached
+		{http.MethodGet, "/", "1", nil},
+		{http.MethodGet, "/", "4", []string{"unknown", "route"}},
+		{http.MethodGet, "/", "4", []string{"unknown"}},
+	} {
+		if test.invalidate != nil {
+			(*Server)(s).InvalidateCache(test.invalidate...)
+		}
+
+		if got := get(test.method, test.target); got != test.want {
+			t.Errorf("request %d: want: %q, got: %q", i+1, test.want, got)
+		}
+	}
+
+	// A tag added by the handler
+	var tagged = newTestServer(t, Route{
+		RouteData: RouteData{Path: "/"},
+		Cache:     &Cache{TTL: time.Hour},
+		Handler: func(r *RouteResponse, _ *RouteData) {
+			r.CacheTags(r.Query("tag"))
+			r.WriteText(r.Query("tag") + strconv.Itoa(int(atomic.AddInt32(&renders, 1))))
+		},
+	})
+
+	var first = serveTestRequest(tagged, httptest.NewRequest(http.MethodGet, "/?tag=a", nil)).Body.String()
+	var other = serveTestRequest(tagged, httptest.NewRequest(http.MethodGet, "/?tag=b", nil)).Body.String()
+
+	(*Server)(tagged).InvalidateCache("a")
+
+	if got := serveTestRequest(tagged, httptest.NewRequest(http.MethodGet, "/?tag=a", nil)).Body.String(); got == first {
+		t.Errorf("the page tagged %q was not removed", "a")
+	}
+	if got := serveTestRequest(tagged, httptest.NewRequest(http.MethodGet, "/?tag=b", nil)).Body.String(); got != other {
+		t.Errorf("the page tagged %q was removed", "b")
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	var renders int32
+
+	var s = newTestServer(t, Route{
+		RouteData: RouteData{Path: "/"},
+		Cache:     &Cache{TTL: time.Millisecond, StaleWhileRevalidate: time.Hour},
+		Handler: func(r *RouteResponse, _ *RouteData) {
+			r.WriteText(strconv.Itoa(int(atomic.AddInt32(&renders, 1))))
+		},
+	})
+
+	var get = func() string {
+		return serveTestRequest(s, httptest.NewRequest(http.MethodGet, "/", nil)).Body.String()
+	}
+
+	if got := get(); got != "1" {
+		t.Fatalf("got: %q", got)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	// The stale page is served, while a fresh one is rendered
+	if got := get(); got != "1" {
+		t.Fatalf("stale: got: %q", got)
+	}
+
+	for i := 0; atomic.LoadInt32(&renders) < 2; i++ {
+		if i == 100 {
+			t.Fatal("the page was not rendered again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; get() != "2"; i++ {
+		if i == 100 {
+			t.Fatal("the fresh page was not stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCacheNegotiate(t *testing.T) {
+	var renders = 0
+
+	var s = newTestServer(t, Route{
+		RouteData: RouteData{Path: "/"},
+		Cache:     &Cache{TTL: time.Hour},
+		Handler: func(r *RouteResponse, _ *RouteData) {
+			renders++
+			if r.Negotiate(ContentHTML, ContentJSON) == ContentJSON {
+				r.WriteJSON("json")
+			} else {
+				r.WriteText("html")
+			}
+		},
+	})
+
+	for i, accept := range []string{"application/json", "text/html", "application/json", "text/html"} {
+		var req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(_accept, accept)
+
+		var rec = serveTestRequest(s, req)
+
+		var want = map[string]string{"application/json": `"json"`, "text/html": "html"}[accept]
+		if rec.Body.String() != want {
+			t.Errorf("request %d: want: %q, got: %q", i+1, want, rec.Body.String())
+		}
+		if vary := rec.Header().Values(_vary); !reflect.DeepEqual(vary, []string{_accept}) {
+			t.Errorf("request %d: vary: got: %q", i+1, vary)
+		}
+	}
+
+	// The first page is not stored, since its key had no Accept header
+	if renders != 3 {
+		t.Errorf("renders: want: 3, got: %d", renders)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	var s server
+	if err := s.setTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var remoteIP = func(remoteAddr, forwarded string) string {
+		var req = http.Request{RemoteAddr: remoteAddr, Header: http.Header{}}
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return s.remoteIP(&req).String()
+	}
+
+	testResult(t, remoteIP, "203.0.113.7").
+		with("203.0.113.7:1234", "").
+		with("203.0.113.7:1234", "198.51.100.1").
+		with("10.1.2.3:1234", "203.0.113.7").
+		with("192.168.1.1:1234", "198.51.100.1, 203.0.113.7, 10.0.0.2").
+		run()
+}
+
+func TestSecret(t *testing.T) {
+	var sec, err = newSecret(testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var signed = sec.sign("cookie", "value")
+
+	if val, ok := sec.verify("cookie", signed); !ok || val != "value" {
+		t.Errorf("verify: got: %q, %t", val, ok)
+	}
+	if _, ok := sec.verify("other", signed); ok {
+		t.Errorf("verify: accepted a different purpose")
+	}
+	if _, ok := sec.verify("cookie", "x"+signed); ok {
+		t.Errorf("verify: accepted an altered value")
+	}
+
+	var encrypted = sec.encrypt("cookie", "value")
+
+	if val, ok := sec.decrypt("cookie", encrypted); !ok || val != "value" {
+		t.Errorf("decrypt: got: %q, %t", val, ok)
+	}
+	if _, ok := sec.decrypt("other", encrypted); ok {
+		t.Errorf("decrypt: accepted a different purpose")
+	}
+}
+
+var testSecretKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSession(t *testing.T) {
+	var store = NewMemorySessionStore()
+
+	var s = newTestServer(t, Route{
+		RouteData: RouteData{Path: "/"},
+		Cache:     &Cache{TTL: time.Hour},
+		Handler: func(r *RouteResponse, _ *RouteData) {
+			var sess = r.Session()
+
+			switch {
+			case len(r.Query("user")) != 0:
+				sess.Set("user", r.Query("user"))
+			case r.Query("do") == "rotate":
+				sess.RotateID()
+			case r.Query("do") == "destroy":
+				sess.Destroy()
+			}
+
+			r.WriteText(sess.Get("user"))
+		},
+	})
+
+	if err := s.setSecretKey(testSecretKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setSessions(SessionConfig{Store: store}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ann, other = testJar{}, testJar{}
+
+	if got := ann.get(s, "/?user=ann"); got != "ann" {
+		t.Fatalf("set: got: %q", got)
+	}
+	var first = ann[_defaultSessionCookie]
+	if first == nil || len(store.sessions) != 1 {
+		t.Fatalf("set: cookie: %v, stored: %d", first, len(store.sessions))
+	}
+
+	if got := ann.get(s, "/"); got != "ann" {
+		t.Errorf("load: got: %q", got)
+	}
+
+	// The page read the session, so it was not cached for others
+	if got := other.get(s, "/"); got != "" {
+		t.Errorf("another user: got: %q", got)
+	}
+
+	if got := ann.get(s, "/?do=rotate"); got != "ann" {
+		t.Errorf("rotate: got: %q", got)
+	}
+	if ann[_defaultSessionCookie].Value == first.Value || len(store.sessions) != 1 {
+		t.Errorf("rotate: the ID was kept, stored: %d", len(store.sessions))
+	}
+	if got := (testJar{_defaultSessionCookie: first}).get(s, "/"); got != "" {
+		t.Errorf("rotate: the old ID still loads %q", got)
+	}
+
+	if got := ann.get(s, "/"); got != "ann" {
+		t.Errorf("after rotate: got: %q", got)
+	}
+
+	ann.get(s, "/?do=destroy")
+
+	if ann[_defaultSessionCookie] != nil || len(store.sessions) != 0 {
+		t.Errorf("destroy: cookie: %v, stored: %d", ann[_defaultSessionCookie], len(store.sessions))
+	}
+	if got := ann.get(s, "/"); got != "" {
+		t.Errorf("after destroy: got: %q", got)
+	}
+}
+
+func TestFlash(t *testing.T) {
+	for _, withSessions := range []bool{false, true} {
+		var s = newTestServer(t, Route{
+			RouteData: RouteData{Path: "/"},
+			Handler: func(r *RouteResponse, _ *RouteData) {
+				if msg := r.Query("msg"); len(msg) != 0 {
+					r.Flash("info", msg)
+				}
+
+				var out []string
+				for _, f := range r.Flashes() {
+					out = append(out, f.Kind+":"+f.Message)
+				}
+				r.WriteText(strings.Join(out, ","))
+			},
+		})
+
+		if err := s.setSecretKey(testSecretKey); err != nil {
+			t.Fatal(err)
+		}
+		if withSessions {
+			if err := s.setSessions(SessionConfig{Store: NewMemorySessionStore()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var jar = testJar{}
+
+		for i, test := range [][2]string{
+			{"/?msg=saved", ""},
+			{"/", "info:saved"},
+			{"/", ""},
+		} {
+			if got := jar.get(s, test[0]); got != test[1] {
+				t.Errorf("sessions %t, request %d: want: %q, got: %q", withSessions, i+1, test[1], got)
+			}
+		}
+
+		if !withSessions && jar[_flashCookie] != nil {
+			t.Errorf("the flash cookie was not expired")
+		}
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	var form = NewComponent(CSS(""), JS(""),
+		HTML(`<form data-freak="form"></form>`, None),
+		Marker[string]{Name: "form", Positions: Positions[string]{Post[string](func(r *response[string], _ string) {
+			r.WriteCSRFInput()
+		})}},
+	)
+
+	var s = newTestServer(t,
+		form.Route(RouteData{Path: "/form"}, func(*RouteResponse) (string, error) {
+			return "", nil
+		}),
+		Route{
+			RouteData: RouteData{Path: "/submit"},
+			Handler: func(r *RouteResponse, _ *RouteData) {
+				r.WriteText("done")
+			},
+		},
+	)
+
+	if err := s.setSecretKey(testSecretKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setCSRF(CSRFConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setErrorPage(http.StatusForbidden, func(r *RouteResponse, err error) {
+		r.WriteText("refused: " + err.Error())
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var jar = testJar{}
+
+	var input = regexp.MustCompile(`<input type=hidden name="freak-csrf" value="([^"]+)">`).
+		FindStringSubmatch(jar.get(s, "/form"))
+	if input == nil {
+		t.Fatalf("the input was not rendered")
+	}
+	var token = input[1]
+
+	var post = func(jar testJar, field, header string) (int, string) {
+		var req = httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader("freak-csrf="+field))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set(_defaultCSRFHeader, header)
+		}
+
+		var rec = jar.serve(s, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	var refused = "refused: " + ErrCSRF.Error()
+
+	for i, test := range []struct {
+		jar           testJar
+		field, header string
+		code          int
+		body          string
+	}{
+		{jar, "", "", http.StatusForbidden, refused},
+		{jar, "wrong", "", http.StatusForbidden, refused},
+		{jar, "", "wrong", http.StatusForbidden, refused},
+		{testJar{}, token, "", http.StatusForbidden, refused}, // Without the cookie
+		{jar, token, "", http.StatusOK, "done"},
+		{jar, "", token, http.StatusOK, "done"},
+	} {
+		if code, body := post(test.jar, test.field, test.header); code != test.code || body != test.body {
+			t.Errorf("request %d: want: %d, %q, got: %d, %q", i+1, test.code, test.body, code, body)
+		}
+	}
+
+	// Safe methods are not checked
+	if got := jar.get(s, "/submit"); got != "done" {
+		t.Errorf("GET: got: %q", got)
+	}
+}
+
+func TestCSPNonce(t *testing.T) {
+	var comp = NewComponent[string](CSS(""), JS(""), HTML(`<script></script>`, None))
+
+	var page = NewPage(
+		Head[string]{Link: []HeadMarker[string]{{Static: "https://cdn.example.com/a.css"}}},
+		nil,
+		func(r *RouteResponse, data string) {
+			Insert(r, comp, data)
+		},
+	)
+
+	var route = page.Route(RouteData{Path: "/"}, func(*RouteResponse) (string, error) {
+		return "", nil
+	})
+	route.Cache = &Cache{TTL: time.Hour}
+
+	var s = newTestServer(t, route)
+
+	if err := s.setContentSecurityPolicy(CSPConfig{Policy: "default-src 'self'"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var nonceRe = regexp.MustCompile(`script-src 'nonce-([^']+)'`)
+	var seen = map[string]bool{}
+
+	for i := 0; i < 2; i++ {
+		var rec = serveTestRequest(s, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		var policy = strings.Join(rec.Header().Values(_csp), ", ")
+
+		var m = nonceRe.FindStringSubmatch(policy)
+		if m == nil {
+			t.Fatalf("request %d: no nonce in %q", i+1, policy)
+		}
+		if seen[m[1]] {
+			t.Errorf("request %d: the nonce was used before", i+1)
+		}
+		seen[m[1]] = true
+
+		// The framework's stylesheet and script, the head's link, and the
+		// component's script
+		var body = rec.Body.String()
+		var tags = regexp.MustCompile(`<(link|script)[^>]*>`).FindAllString(body, -1)
+
+		if len(tags) != 4 {
+			t.Fatalf("request %d: want 4 tags, got: %q", i+1, body)
+		}
+		for _, tag := range tags {
+			if !strings.Contains(tag, ` nonce="`+m[1]+`"`) {
+				t.Errorf("request %d: no nonce in %q", i+1, tag)
+			}
+		}
+	}
+}
+
+func TestNonceMarkerWithoutPolicy(t *testing.T) {
+	var comp = NewComponent[string](CSS(""), JS(""),
+		HTML(`<p>a</p><script></script><style></style><link rel=stylesheet href=a.css>`, None),
+	)
+
+	var w = &countingWriter{}
+	var r = response[string]{&responseCore{writer: w}}
+
+	r.insert(comp.component, "", nil)
+
+	var body = regexp.MustCompile(` data-freak=[^ >]+`).ReplaceAllString(w.String(), "")
+
+	if want := `<p>a</p><script></script><style></style><link href=a.css rel=stylesheet>`; body != want {
+		t.Errorf("want: %q\ngot: %q", want, body)
+	}
+
+	// The compiled HTML is written in one piece
+	if w.writes != 1 {
+		t.Errorf("want: 1 write, got: %d", w.writes)
+	}
+}
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(b)
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	var route = func(path string, h *SecurityHeaders) Route {
+		return Route{
+			RouteData:       RouteData{Path: path},
+			SecurityHeaders: h,
+			Handler:         func(r *RouteResponse, _ *RouteData) { r.WriteText("ok") },
+		}
+	}
+
+	var s = newTestServer(t,
+		route("/", nil),
+		route("/deny/", &SecurityHeaders{Frame: FrameDeny}),
+	)
+
+	var headers = func(target string) http.Header {
+		return serveTestRequest(s, httptest.NewRequest(http.MethodGet, target, nil)).Header()
+	}
+
+	// None are sent until they're set
+	for _, key := range []string{_hsts, _contentTypeOpts, _frameOptions, _referrerPolicy, _csp} {
+		if got := headers("https://example.com/").Get(key); got != "" {
+			t.Errorf("not set: %s: got: %q", key, got)
+		}
+	}
+
+	if err := s.setSecurityHeaders(DefaultSecurityHeaders()); err != nil {
+		t.Fatal(err)
+	}
+
+	var hdrs = headers("https://example.com/")
+
+	for key, want := range map[string]string{
+		_hsts:            "max-age=31536000",
+		_contentTypeOpts: "nosniff",
+		_frameOptions:    "SAMEORIGIN",
+		_referrerPolicy:  "strict-origin-when-cross-origin",
+		_csp:             "frame-ancestors 'self'",
+	} {
+		if got := hdrs.Get(key); got != want {
+			t.Errorf("default %s: want: %q, got: %q", key, want, got)
+		}
+	}
+
+	if got := headers("http://example.com/").Get(_hsts); got != "" {
+		t.Errorf("HSTS was sent without TLS: %q", got)
+	}
+
+	hdrs = headers("https://example.com/deny/")
+
+	if hdrs.Get(_frameOptions) != "DENY" || hdrs.Get(_csp) != "frame-ancestors 'none'" ||
+		hdrs.Get(_contentTypeOpts) != "" || hdrs.Get(_hsts) != "" {
+		t.Errorf("route headers: got: %v", hdrs)
+	}
+
+	if err := s.setSecurityHeaders(SecurityHeaders{ReferrerPolicy: SkipReferrer}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{_hsts, _contentTypeOpts, _frameOptions, _referrerPolicy, _csp, _permissionsPolicy} {
+		if got := headers("https://example.com/").Get(key); got != "" {
+			t.Errorf("turned off: %s: got: %q", key, got)
+		}
+	}
+}
+
+func TestFileSessionStore(t *testing.T) {
+	var store, err = NewFileSessionStore(filepath.Join(t.TempDir(), "sessions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var now = time.Now()
+
+	for id, expires := range map[string]time.Time{"live": now.Add(time.Hour), "expired": now.Add(-time.Hour)} {
+		if err = store.Save(id, &SessionData{Values: map[string]string{"id": id}, Expires: expires}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := store.Load("live")
+	if err != nil || data == nil || data.Values["id"] != "live" {
+		t.Fatalf("load: got: %v, %v", data, err)
+	}
+
+	if data, err = store.Load("missing"); data != nil || err != nil {
+		t.Errorf("missing: got: %v, %v", data, err)
+	}
+
+	for _, id := range []string{"", "../live", "a/b", "a.b"} {
+		if _, err = store.Load(id); err == nil {
+			t.Errorf("%q: the ID was accepted", id)
+		}
+	}
+
+	if err = store.GC(now); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ = store.Load("expired"); data != nil {
+		t.Errorf("GC: the expired session was kept")
+	}
+	if data, _ = store.Load("live"); data == nil {
+		t.Errorf("GC: the live session was removed")
+	}
+
+	if err = store.Delete("live"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Delete("live"); err != nil {
+		t.Errorf("deleting a missing session: %v", err)
+	}
+	if data, _ = store.Load("live"); data != nil {
+		t.Errorf("delete: the session was kept")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	var l, err = newRateLimiter(Rate{Requests: 1, Per: time.Second, Burst: 2}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var client = netip.MustParseAddr("203.0.113.7")
+	var now = time.Now()
+
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := l.allow(client, now); ok != want {
+			t.Errorf("request %d: want: %t, got: %t", i+1, want, ok)
+		}
+	}
+
+	if _, wait := l.allow(client, now); wait <= 0 || wait > time.Second {
+		t.Errorf("wait: got: %s", wait)
+	}
+
+	if ok, _ := l.allow(client, now.Add(time.Second)); !ok {
+		t.Errorf("after refill: got: false")
+	}
+}
+
+func TestRouteRateLimit(t *testing.T) {
+	var s = newTestServer(t, Route{
+		RouteData: RouteData{Path: "/"},
+		RateLimit: &Rate{Requests: 1, Per: time.Hour},
+		Handler:   func(r *RouteResponse, _ *RouteData) { r.WriteText("ok") },
+	})
+
+	// Called after SetRoutes, the expiry still reaches the route's limiter
+	if err := s.setRateLimits(RateLimits{IdleExpiry: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.routes["/"].limiter.idleExpiry; got != time.Hour {
+		t.Errorf("idle expiry: got: %s", got)
+	}
+
+	var serve = func(remoteAddr string) *httptest.ResponseRecorder {
+		var req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		return serveTestRequest(s, req)
+	}
+
+	for i, test := range []struct {
+		remoteAddr string
+		code       int
+	}{
+		{"203.0.113.7:1234", http.StatusOK},
+		{"203.0.113.7:1234", http.StatusTooManyRequests},
+		{"203.0.113.8:1234", http.StatusOK},
+		// Clients that can't be told apart are not limited
+		{"@", http.StatusOK},
+		{"@", http.StatusOK},
+	} {
+		var rec = serve(test.remoteAddr)
+
+		if rec.Code != test.code {
+			t.Errorf("request %d: want: %d, got: %d", i+1, test.code, rec.Code)
+		}
+		if test.code == http.StatusTooManyRequests && rec.Header().Get(_retryAfter) == "" {
+			t.Errorf("request %d: no Retry-After", i+1)
+		}
+	}
+
+	// Without any limit, nothing is checked
+	var plain = newTestServer(t, Route{
+		RouteData: RouteData{Path: "/"},
+		Handler:   func(r *RouteResponse, _ *RouteData) { r.WriteText("ok") },
+	})
+
+	var req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "@"
+
+	if rec := serveTestRequest(plain, req); rec.Code != http.StatusOK {
+		t.Errorf("no limits: got: %d", rec.Code)
+	}
+}
+
+func TestNilPositions(t *testing.T) {
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<p data-freak="name"></p><img src=x.png data-freak="img">`, None),
+		// Positions that are left out are nil interfaces
+		Marker[string]{Name: "name", Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+			r.WriteString(s)
+		})}},
+		// ...or nil functions
+		Marker[string]{Name: "img", Positions: Positions[string]{
+			Pre[string](nil),
+			Attrs[string](func(r *AttrResponse[string], _ string) {
+				r.AddAttrNoEscape("alt", "x")
+			}),
+			Post[string](nil),
+		}},
+	)
+
+	var body = renderTestComponent(comp, "name")
+
+	for _, want := range []string{`<p>name</p>`, `<img src=x.png alt="x">`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want: %q\ngot: %q", want, body)
+		}
+	}
+}
+
+// renderTestComponent renders the component with the data, without a server.
+// The data-freak attributes are left out.
+func renderTestComponent[T any](c Component[T], data T) string {
+	var b bytes.Buffer
+	var r = response[T]{&responseCore{writer: &b}}
+
+	r.insert(c.component, data, nil)
+
+	return regexp.MustCompile(` data-freak=[^ >]+`).ReplaceAllString(b.String(), "")
+}
+
+func TestAddAttr(t *testing.T) {
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<p data-freak="attrs"></p>`, None),
+		Marker[string]{Name: "attrs", Positions: Positions[string]{Attrs[string](func(r *AttrResponse[string], s string) {
+			r.AddAttr("title", s)
+			r.AddAttrNoEscape("class", "a")
+		})}},
+	)
+
+	if body, want := renderTestComponent(comp, `"x"`), `<p title="&#34;x&#34;" class="a"></p>`; body != want {
+		t.Errorf("want: %q\ngot: %q", want, body)
+	}
+}
+
+func TestVoidElements(t *testing.T) {
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<p>a<br>b</p><input name=x data-freak="input"><hr>`, None),
+		Marker[string]{Name: "input", Positions: Positions[string]{Attrs[string](func(r *AttrResponse[string], s string) {
+			r.AddAttr("value", s)
+		})}},
+	)
+
+	if body, want := renderTestComponent(comp, "y"), `<p>a<br>b</p><input name=x value="y"><hr>`; body != want {
+		t.Errorf("want: %q\ngot: %q", want, body)
+	}
+}
+
+func TestSkipElementAndContent(t *testing.T) {
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<div data-freak="el">a</div><p data-freak="content">c</p><b>b</b>`, None),
+		Marker[string]{Name: "el", Positions: Positions[string]{Pre[string](func(r *response[string], s string) {
+			if s == "skip" {
+				r.SkipElement()
+			}
+		})}},
+		Marker[string]{Name: "content", Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+			if s == "skip" {
+				r.WriteString("new")
+				r.SkipContent()
+			}
+		})}},
+	)
+
+	for data, want := range map[string]string{
+		"show": `<div>a</div><p>c</p><b>b</b>`,
+		"skip": `<p>new</p><b>b</b>`,
+	} {
+		if body := renderTestComponent(comp, data); body != want {
+			t.Errorf("%s: want: %q\ngot: %q", data, want, body)
+		}
+	}
+}
+
+func TestSkipNestedMarkers(t *testing.T) {
+	var calls = 0
+
+	var inner = func(r *response[string], _ string) {
+		calls++
+		r.WriteString("[inner]")
+	}
+
+	var el = NewComponent(CSS(""), JS(""),
+		HTML(`<div data-freak="el"><b data-freak="inner">b</b></div><hr>`, None),
+		Marker[string]{Name: "el", Positions: Positions[string]{Pre[string](func(r *response[string], _ string) {
+			r.SkipElement()
+		})}},
+		Marker[string]{Name: "inner", Positions: Positions[string]{Post[string](inner)}},
+	)
+
+	var content = NewComponent(CSS(""), JS(""),
+		HTML(`<p data-freak="content"><i data-freak="inner">i</i></p>`, None),
+		Marker[string]{Name: "content", Positions: Positions[string]{Post[string](func(r *response[string], _ string) {
+			r.SkipContent()
+		})}},
+		Marker[string]{Name: "inner", Positions: Positions[string]{Post[string](inner)}},
+	)
+
+	for comp, want := range map[Component[string]]string{el: `<hr>`, content: `<p></p>`} {
+		if body := renderTestComponent(comp, ""); body != want {
+			t.Errorf("want: %q\ngot: %q", want, body)
+		}
+	}
+
+	if calls != 0 {
+		t.Errorf("nested markers ran %d times", calls)
+	}
+}
+
+func TestPageRender(t *testing.T) {
+	var greeting = NewComponent(CSS(""), JS(""),
+		HTML(`<p data-freak="name">placeholder</p>`, None),
+		Marker[string]{
+			Name: "name",
+			Positions: Positions[string]{
+				Post[string](func(r *response[string], name string) {
+					r.WriteString(name)
+					r.SkipContent()
+				}),
+			},
+		},
+	)
+
+	var page = NewPage(
+		Head[string]{Title: HeadMarker[string]{Static: "A & B"}},
+		map[string]string{"class": "main"},
+		func(r *RouteResponse, name string) {
+			Insert(r, greeting, name)
+		},
+	)
+
+	var body = serveTestRoute(t, page.Route(RouteData{Path: "/"}, func(*RouteResponse) (string, error) {
+		return "<World>", nil
+	}))
+
+	for _, want := range []string{
+		`<!DOCTYPE html><html><head><title>A &amp; B</title><meta charset=UTF-8><link `,
+		`<script src=` + _jsInsertionPath + `></script></head>`,
+		`<body class=main data-freak=`,
+		`>&lt;World&gt;</p></body></html>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want: %q\ngot: %q", want, body)
+		}
+	}
+}
+
+func TestPageDynamicHead(t *testing.T) {
+	var page = NewPage(
+		Head[string]{
+			Title: HeadMarker[string]{Static: "Site: ", Dynamic: func(r *response[string], name string) {
+				r.WriteString(name)
+			}},
+			Canonical: HeadMarker[string]{Static: "https://example.com", Dynamic: func(r *response[string], _ string) {
+				r.WriteString(r.SiteMapNode().Path())
+			}},
+			Description: HeadMarker[string]{Dynamic: func(r *response[string], name string) {
+				r.WriteString("About " + name)
+			}},
+		},
+		nil,
+		func(r *RouteResponse, name string) {},
+	)
+
+	var body = serveTestRoute(t, page.Route(RouteData{Path: "/about"}, func(*RouteResponse) (string, error) {
+		return "A & B", nil
+	}))
+
+	for _, want := range []string{
+		`>Site: A &amp; B</title>`,
+		` href="https://example.com/about">`,
+		` content="About A &amp; B">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want: %q\ngot: %q", want, body)
+		}
+	}
+}
+
+func TestComponentRoute(t *testing.T) {
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<p data-freak="name"></p>`, None),
+		Marker[string]{
+			Name: "name",
+			Positions: Positions[string]{
+				Post[string](func(r *response[string], name string) {
+					r.WriteString(name)
+				}),
+			},
+		},
+	)
+
+	var body = serveTestRoute(t, comp.Route(RouteData{Path: "/frag"}, func(*RouteResponse) (string, error) {
+		return "fragment", nil
+	}))
+
+	if !strings.HasPrefix(body, "<p ") || !strings.HasSuffix(body, ">fragment</p>") {
+		t.Errorf("got: %q", body)
+	}
+
+	code, body := serveTestRouteStatus(t, comp.Route(RouteData{Path: "/missing"}, func(*RouteResponse) (string, error) {
+		return "", &StatusError{Code: http.StatusNotFound}
+	}))
+
+	if code != http.StatusNotFound || body != http.StatusText(http.StatusNotFound) {
+		t.Errorf("got: %d %q", code, body)
+	}
+}
+
+func TestMarkersByName(t *testing.T) {
+	var write = func(s string) Positions[int] {
+		return Positions[int]{Post[int](func(r *response[int], _ int) { r.WriteString(s) })}
+	}
+
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<p data-freak="a"></p><p data-freak="b"></p><p data-freak="a"></p>`, None),
+		Marker[int]{Name: "b", Positions: write("B")},
+		Marker[int]{Name: "a", Positions: write("A")},
+	)
+
+	var body = serveTestRoute(t, comp.Route(RouteData{Path: "/"}, func(*RouteResponse) (int, error) {
+		return 0, nil
+	}))
+
+	if strings.Count(body, ">A</p>") != 2 || strings.Count(body, ">B</p>") != 1 {
+		t.Errorf("got: %q", body)
+	}
+
+	_, err := CompileComponent(CSS(""), JS(""),
+		HTML("<p data-freak=\"a\"></p>\n  <i data-freak=\"missing\"></i>", None),
+		Marker[int]{Name: "a", Positions: write("A")},
+		Marker[int]{Name: "a", Positions: write("A")},
+		Marker[int]{Name: "unused", Positions: write("U")},
+	)
+
+	var want = `component: the "a" marker is given more than once
+component:2:6: no marker callbacks found for the "missing" marker
+component: no data-freak attribute found for the "unused" marker`
+
+	if err == nil || err.Error() != want {
+		t.Errorf("want: %s\ngot: %v", want, err)
+	}
+}
+
+func TestCompileErrorPositions(t *testing.T) {
+	_, err := CompileComponent[bindingTestArticle](CSS(""), JS(""), HTML(
+		"<p data-freak=\"missing\"></p>\n"+
+			"<table><tr><td class=x data-freak=\"missing\"></td></tr></table>\n"+
+			"<!-- freak-wrapped-content --><i data-freak-text=\"Nope\"></i>\n"+
+			"  <b data-freak-text=\"Nope\"></b><!-- freak-wrapped-content -->",
+		None,
+	))
+
+	var want = `component:1:4: no marker callbacks found for the "missing" marker
+component:2:24: no marker callbacks found for the "missing" marker
+component:3:34: data-freak-text: freak.bindingTestArticle has no exported field or method Nope
+component:4:6: data-freak-text: freak.bindingTestArticle has no exported field or method Nope
+component:4:33: only one "freak-wrapped-content" is permitted in a component`
+
+	if err == nil || err.Error() != want {
+		t.Errorf("want: %s\ngot: %v", want, err)
+	}
+}
+
+func TestAsResponse(t *testing.T) {
+	var r = &response[string]{&responseCore{}}
+
+	var child = asResponse[int](r)
+
+	if asResponse[int](r) != child || asResponse[string](r) != r {
+		t.Errorf("the responses were not kept")
+	}
+
+	child.SkipContent()
+
+	if !r.componentState.has(skipContent) {
+		t.Errorf("the state is not shared")
+	}
+}
+
+func TestInsertChild(t *testing.T) {
+	var count = NewComponent(CSS(""), JS(""),
+		HTML(`<b data-freak="n"></b>`, None),
+		Marker[int]{Name: "n", Positions: Positions[int]{Post[int](func(r *response[int], n int) {
+			r.WriteStringNoEscape(strconv.Itoa(n))
+		})}},
+	)
+
+	var wrapper = NewComponent[int](CSS(""), JS(""),
+		HTML(`<section><!-- freak-wrapped-content --></section>`, None),
+	)
+
+	var parent = NewComponent(CSS(""), JS(""),
+		HTML(`<div data-freak="wrapped">text</div><p data-freak="count"></p>`, None),
+		Marker[string]{Name: "wrapped", Positions: Positions[string]{Pre[string](func(r *response[string], s string) {
+			InsertChild(r, wrapper, len(s))
+		})}},
+		Marker[string]{Name: "count", Positions: Positions[string]{
+			Child(count, func(s string) int { return len(s) }),
+		}},
+	)
+
+	var body = serveTestRoute(t, parent.Route(RouteData{Path: "/"}, func(*RouteResponse) (string, error) {
+		return "four", nil
+	}))
+
+	for _, want := range []string{`<section `, `>text</div></section>`, `>4</b></p>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want: %q\ngot: %q", want, body)
+		}
+	}
+}
+
+func TestInsertEach(t *testing.T) {
+	var item = NewComponent(CSS(""), JS(""),
+		HTML(`<li data-freak="n"></li>`, None),
+		Marker[string]{Name: "n", Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+			var it = r.Item()
+			r.WriteString(strconv.Itoa(it.Index) + s)
+			if it.First {
+				r.WriteString("F")
+			}
+			if it.Last {
+				r.WriteString("L")
+			}
+		})}},
+	)
+
+	var list = NewComponent(CSS(""), JS(""),
+		HTML(`<ul data-freak="items"></ul><ol data-freak="seq"></ol>`, None),
+		Marker[[]string]{Name: "items", Positions: Positions[[]string]{Post[[]string](func(r *response[[]string], items []string) {
+			InsertEach(r, item, items, func() { r.WriteString("none") })
+		})}},
+		Marker[[]string]{Name: "seq", Positions: Positions[[]string]{Post[[]string](func(r *response[[]string], items []string) {
+			InsertSeq(r, item, func(yield func(string) bool) {
+				for _, s := range items {
+					if !yield(s) {
+						return
+					}
+				}
+			}, nil)
+		})}},
+	)
+
+	for items, want := range map[string][]string{
+		"a,b,c": {">0aF</li>", ">1b</li>", ">2cL</li>"},
+		"a":     {">0aFL</li>"},
+		"":      {">none</ul><ol data-freak=", "></ol>"},
+	} {
+		var body = serveTestRoute(t, list.Route(RouteData{Path: "/"}, func(*RouteResponse) ([]string, error) {
+			if items == "" {
+				return nil, nil
+			}
+			return strings.Split(items, ","), nil
+		}))
+
+		for _, w := range want {
+			if strings.Count(body, w) != 2 && !(items == "" && strings.Contains(body, w)) {
+				t.Errorf("want: %q\ngot: %q", w, body)
+			}
+		}
+	}
+}
+
+func TestInsertEachAllocs(t *testing.T) {
+	var item = NewComponent(CSS(""), JS(""),
+		HTML(`<li data-freak="n"></li>`, None),
+		Marker[int]{Name: "n", Positions: Positions[int]{Post[int](func(r *response[int], n int) {
+			r.WriteStringNoEscape(strconv.Itoa(n % 10))
+		})}},
+	)
+
+	s, err := newServer("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r = getResponse((*server)(s), httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), nil, false)
+	var items = make([]int, 100)
+
+	var allocs = testing.AllocsPerRun(100, func() {
+		r.buf.Reset()
+		InsertEach(&r.response, item, items, nil)
+	})
+
+	if allocs != 0 {
+		t.Errorf("want: 0 allocations, got: %v", allocs)
+	}
+}
+
+func TestInsertSlots(t *testing.T) {
+	var layout = NewComponent[string](CSS(""), JS(""),
+		HTML(`<header><!-- freak-slot: header --></header><main><!-- freak-slot:main--></main>`, None),
+	)
+
+	var wrapper = NewComponent[string](CSS(""), JS(""),
+		HTML(`<section><!-- freak-wrapped-content --></section>`, None),
+	)
+
+	var page = NewComponent(CSS(""), JS(""),
+		HTML(`<div data-freak="layout"></div>`, None),
+		Marker[string]{Name: "layout", Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+			InsertSlots(r, layout, "", Slots{
+				"header": func() { r.WriteString(s) },
+				"main": func() {
+					InsertChild(r, wrapper, "")
+					InsertSlots(r, layout, "", Slots{"header": func() { r.WriteString("inner") }})
+				},
+			})
+		})}},
+	)
+
+	var body = serveTestRoute(t, page.Route(RouteData{Path: "/"}, func(*RouteResponse) (string, error) {
+		return "outer", nil
+	}))
+
+	// The wrapper wraps what the slot's callback writes after it
+	for _, want := range []string{
+		`>outer</header><main data-freak=`,
+		`><section data-freak=`,
+		`>inner</header><main data-freak=`,
+		`></main></section></main></div>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want: %q\ngot: %q", want, body)
+		}
+	}
+
+	_, err := CompileComponent[int](CSS(""), JS(""),
+		HTML("<p><!-- freak-slot: a --></p>\n<!-- freak-slot: a -->", None),
+	)
+
+	if want := `component:2:1: the "a" slot is used more than once`; err == nil || err.Error() != want {
+		t.Errorf("want: %s\ngot: %v", want, err)
+	}
+}
+
+type bindingTestAuthor struct {
+	Name string
+}
+
+type bindingTestArticle struct {
+	Title  string
+	Author *bindingTestAuthor
+	Tags   []string
+}
+
+func (a bindingTestArticle) URL() string {
+	return "/articles?title=" + a.Title
+}
+
+func TestBindings(t *testing.T) {
+	var comp = NewComponent[bindingTestArticle](CSS(""), JS(""), HTML(
+		`<a href="/old" data-freak-attr-href="URL" data-freak-text="Title">x</a>`+
+			`<i data-freak-if="Author" data-freak-text="Author.Name"></i>`+
+			`<b data-freak-if="!Tags">untagged</b>`,
+		None,
+	))
+
+	for article, want := range map[*bindingTestArticle]string{
+		{Title: `A & "B"`, Author: &bindingTestAuthor{Name: "<Ann>"}}: `<a href="/articles?title=A & &#34;B&#34;">A &amp; &#34;B&#34;</a>` +
+			`<i>&lt;Ann&gt;</i><b>untagged</b>`,
+		{Title: "C", Tags: []string{"go"}}: `<a href="/articles?title=C">C</a>`,
+	} {
+		var data = *article
+
+		var body = serveTestRoute(t, comp.Route(RouteData{Path: "/"}, func(*RouteResponse) (bindingTestArticle, error) {
+			return data, nil
+		}))
+
+		body = regexp.MustCompile(` data-freak=[^ >]+`).ReplaceAllString(body, "")
+
+		if body != want {
+			t.Errorf("want: %q\ngot: %q", want, body)
+		}
+	}
+
+	_, err := CompileComponent[bindingTestArticle](CSS(""), JS(""),
+		HTML(`<p data-freak-text="Author.Missing"></p>`, None),
+	)
+
+	if err == nil || !strings.Contains(err.Error(), "component:1:4: data-freak-text: ") {
+		t.Errorf("got: %v", err)
+	}
+
+	// A path can't go through an interface
+	_, err = CompileComponent[bindingTestItem](CSS(""), JS(""),
+		HTML(`<p data-freak-text="Any.Name"></p>`, None),
+	)
+
+	if err == nil || !strings.Contains(err.Error(), "component:1:4: data-freak-text: interface {} is an interface") {
+		t.Errorf("interface: got: %v", err)
+	}
+}
+
+type bindingTestItem struct {
+	Any     any
+	Article *bindingTestArticle
+}
+
+func TestBindingsOfPointer(t *testing.T) {
+	var comp = NewComponent[*bindingTestItem](CSS(""), JS(""), HTML(
+		`<p data-freak-text="Article.Author.Name"></p><a data-freak-attr-href="Article.URL"></a>`,
+		None,
+	))
+
+	var item = &bindingTestItem{Article: &bindingTestArticle{Title: "T", Author: &bindingTestAuthor{Name: "Ann"}}}
+
+	if body, want := renderTestComponent(comp, item), `<p>Ann</p><a href="/articles?title=T"></a>`; body != want {
+		t.Errorf("want: %q\ngot: %q", want, body)
+	}
+
+	// A nil pointer on the way gives an empty value
+	if body, want := renderTestComponent(comp, &bindingTestItem{}), `<p></p><a href=""></a>`; body != want {
+		t.Errorf("nil: want: %q\ngot: %q", want, body)
+	}
+
+	var text = NewComponent[*bindingTestItem](CSS(""), JS(""),
+		HTML(`<p data-freak-text="Article.Author.Name"></p>`, None),
+	)
+
+	var r = response[*bindingTestItem]{&responseCore{writer: io.Discard}}
+
+	if allocs := testing.AllocsPerRun(100, func() { r.insert(text.component, item, nil) }); allocs != 0 {
+		t.Errorf("want: 0 allocations, got: %v", allocs)
+	}
+}
+
+func TestLargeTemplate(t *testing.T) {
+	var filler = strings.Repeat("<p>filler</p>", 70000/len("<p>filler</p>"))
+
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(filler+`<b data-freak="name"></b>`+filler+`<i data-freak="name"></i>`, None),
+		Marker[string]{Name: "name", Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+			r.WriteString(s)
+		})}},
+	)
+
+	var body = serveTestRoute(t, comp.Route(RouteData{Path: "/"}, func(*RouteResponse) (string, error) {
+		return "found", nil
+	}))
+
+	if len(body) < 2*len(filler) ||
+		!strings.Contains(body, ";name>found</b>") || !strings.HasSuffix(body, ";name>found</i>") {
+		t.Errorf("got %d bytes, ending with: %q", len(body), body[len(body)-40:])
+	}
+}
+
+func TestDevReload(t *testing.T) {
+	var fsys = fstest.MapFS{
+		"comp.html": {Data: []byte(`<p data-freak="name"></p>`), ModTime: time.Unix(1, 0)},
+		"comp.css":  {Data: []byte(`:root{color:red}`), ModTime: time.Unix(1, 0)},
+	}
+
+	var comp = NewComponent(CSSFS(fsys, "comp.css"), JS(""),
+		HTMLFS(fsys, "comp.html", None),
+		Marker[string]{Name: "name", Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+			r.WriteString(s)
+		})}},
+	)
+
+	s, err := newServer("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var srv = (*server)(s)
+	if err = srv.setDevMode(DevMode{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var events = make(chan struct{}, 1)
+	srv.dev.clients[events] = struct{}{}
+	srv.addDevServer()
+
+	// A second server in dev mode is told by the same watcher
+	other, err := newServer("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var otherSrv = (*server)(other)
+	if err = otherSrv.setDevMode(DevMode{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var otherEvents = make(chan struct{}, 1)
+	otherSrv.dev.clients[otherEvents] = struct{}{}
+	otherSrv.addDevServer()
+
+	// Every render takes the lock now, even of servers not in dev mode
+	if locked := lockRender(); !locked {
+		t.Error("the render lock was not taken")
+	} else {
+		unlockRender(locked)
+	}
+
+	fsys["comp.html"] = &fstest.MapFile{Data: []byte(`<em data-freak="name"></em>`), ModTime: time.Unix(2, 0)}
+	fsys["comp.css"] = &fstest.MapFile{Data: []byte(`:root{color:blue}`), ModTime: time.Unix(2, 0)}
+
+	reloadChanged()
+
+	var body = serveTestRoute(t, comp.Route(RouteData{Path: "/"}, func(*RouteResponse) (string, error) {
+		return "new", nil
+	}))
+
+	if !strings.HasPrefix(body, "<em ") || !strings.HasSuffix(body, ">new</em>") {
+		t.Errorf("got: %q", body)
+	}
+
+	assetMux.Lock()
+	var css = allCss.String()
+	assetMux.Unlock()
+
+	if !strings.Contains(css, "color:blue") || strings.Contains(css, "color:red") {
+		t.Errorf("got CSS: %q", css)
+	}
+
+	for _, ch := range []chan struct{}{events, otherEvents} {
+		select {
+		case <-ch:
+		default:
+			t.Error("no reload event was sent")
+		}
+	}
+}
+
+func TestDevReloadOSFile(t *testing.T) {
+	var pth = filepath.Join(t.TempDir(), "comp.html")
+
+	if err := os.WriteFile(pth, []byte(`<p>old</p>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var comp = NewComponent[string](CSS(""), JS(""), HTMLFile(f, None))
+	f.Close()
+
+	s, err := newServer("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var srv = (*server)(s)
+	if err = srv.setDevMode(DevMode{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(pth, []byte(`<p>new</p>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A later time, since the file system may keep coarse timestamps
+	if err = os.Chtimes(pth, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	reloadChanged()
+
+	var b strings.Builder
+	if err = Render(&b, comp, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := b.String(); !strings.HasSuffix(body, ">new</p>") {
+		t.Errorf("got: %q", body)
+	}
+}
+
+func TestRender(t *testing.T) {
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<p data-freak="name"></p>`, None),
+		Marker[string]{Name: "name", Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+			// Nothing of the request is there, but reading it is safe
+			r.WriteString(s + r.Method() + r.Query("q") + r.Header("Accept"))
+			r.WriteString(strconv.Itoa(len(r.Flashes())))
+		})}},
+	)
+
+	var b strings.Builder
+	if err := Render(&b, comp, "<mail>"); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := b.String(); !strings.HasPrefix(body, "<p ") || !strings.HasSuffix(body, ">&lt;mail&gt;0</p>") {
+		t.Errorf("got: %q", body)
+	}
+
+	var zipped bytes.Buffer
+	if err := RenderGzip(&zipped, comp, "zipped", gzip.BestSpeed); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(&zipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := io.ReadAll(zr); !strings.HasSuffix(string(body), ">zipped0</p>") {
+		t.Errorf("got: %q", body)
+	}
+}
+
+func TestStaticMarkers(t *testing.T) {
+	var calls = 0
+
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<a data-freak="link">old</a><p data-freak="gone"><i data-freak="inner"></i></p>`, None),
+		Marker[string]{
+			Name: "link",
+			Static: Static[string]{
+				Attrs[string](func(r *AttrResponse[string], _ string) {
+					calls++
+					r.AddAttr("href", "/v1.2")
+				}),
+				Post[string](func(r *response[string], _ string) {
+					r.WriteString("v1.2 & ")
+					r.SkipContent()
+				}),
+			},
+			Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+				r.WriteString(s)
+			})},
+		},
+		Marker[string]{Name: "gone", Static: Static[string]{Pre[string](func(r *response[string], _ string) {
+			r.SkipElement()
+		})}},
+		Marker[string]{Name: "inner"},
+	)
+
+	for _, name := range []string{"a", "b"} {
+		var b strings.Builder
+		if err := Render(&b, comp, name); err != nil {
+			t.Fatal(err)
+		}
+
+		var body = regexp.MustCompile(` data-freak=[^ >]+`).ReplaceAllString(b.String(), "")
+
+		if want := `<a href="/v1.2">v1.2 &amp; ` + name + `</a>`; body != want {
+			t.Errorf("want: %q\ngot: %q", want, body)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("static callback ran %d times", calls)
+	}
+
+	var static = NewComponent(CSS(""), JS(""),
+		HTML(`<p><b data-freak="bold">x</b></p>`, None),
+		Marker[string]{Name: "bold", Static: Static[string]{Attrs[string](func(r *AttrResponse[string], _ string) {
+			r.AddAttr("class", "b")
+		})}},
+	)
+
+	if n := len(static.component.markers); n != 0 {
+		t.Errorf("static only marker made %d runtime markers", n)
+	}
+
+	var b strings.Builder
+	if err := Render(&b, static, ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := `<b class="b">x</b>`; !strings.Contains(b.String(), want) {
+		t.Errorf("want: %q\ngot: %q", want, b.String())
+	}
+}
+
+func TestStaticWrapper(t *testing.T) {
+	var wrapper = NewComponent[string](CSS(""), JS(""),
+		HTML(`<section><!-- freak-wrapped-content --></section>`, None),
+	)
+
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<div data-freak="pre">a</div><p data-freak="post">b</p>`, None),
+		Marker[string]{Name: "pre", Static: Static[string]{Pre[string](func(r *response[string], _ string) {
+			InsertChild(r, wrapper, "")
+		})}},
+		Marker[string]{
+			Name: "post",
+			Static: Static[string]{Post[string](func(r *response[string], _ string) {
+				InsertChild(r, wrapper, "")
+			})},
+			Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+				r.WriteString(s)
+			})},
+		},
+	)
+
+	var want = `<section><div>a</div></section><p><section>nameb</section></p>`
+	if got := renderTestComponent(comp, "name"); got != want {
+		t.Errorf("want: %q\ngot: %q", want, got)
+	}
+}
+
+func TestStaticRequestValues(t *testing.T) {
+	_, err := CompileComponent(CSS(""), JS(""),
+		HTML("<p>\n<script data-freak=\"nonce\"></script><form data-freak=\"csrf\"></form></p>", None),
+		Marker[string]{Name: "nonce", Static: Static[string]{Post[string](func(r *response[string], _ string) {
+			r.WriteString(r.Nonce())
+		})}},
+		Marker[string]{Name: "csrf", Static: Static[string]{Post[string](func(r *response[string], _ string) {
+			r.WriteCSRFInput()
+		})}},
+	)
+
+	var want = `component:2:9: Nonce can't be used by a Static callback, since its output is shared by every request
+component:2:43: CSRFToken can't be used by a Static callback, since its output is shared by every request`
+
+	if err == nil || err.Error() != want {
+		t.Errorf("want: %s\ngot: %v", want, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<p data-freak="name"></p>`, None),
+		Marker[string]{Name: "name", Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+			r.WriteString(s)
+		})}},
+	)
+
+	var load = func(data string) func(*RouteResponse) (string, error) {
+		return func(*RouteResponse) (string, error) { return data, nil }
+	}
+
+	s, err := newServer("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var srv = (*server)(s)
+	err = srv.setRoutes([]Route{
+		comp.Route(RouteData{Path: "/export-home/about"}, load("about")),
+		comp.Route(RouteData{Path: "/export-home"}, load("home")),
+		comp.Route(RouteData{Path: "/export-home-b"}, load("b")),
+		comp.Route(RouteData{Path: "/export-missing"}, func(*RouteResponse) (string, error) {
+			return "", &StatusError{Code: http.StatusNotFound}
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dir = t.TempDir()
+
+	result, err := s.Export(ExportConfig{Dir: dir, BaseURL: "https://example.com", Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// In the order of the site map, where parents come before their children
+	if want := []string{"/export-home/", "/export-home/about/", "/export-home-b/"}; !reflect.DeepEqual(result.Pages, want) {
+		t.Errorf("pages: want: %q, got: %q", want, result.Pages)
+	}
+	if want := map[string]int{"/export-missing/": http.StatusNotFound}; !reflect.DeepEqual(result.Skipped, want) {
+		t.Errorf("skipped: want: %v, got: %v", want, result.Skipped)
+	}
+
+	for name, want := range map[string]string{
+		"export-home/index.html":       ">home</p>",
+		"export-home/about/index.html": ">about</p>",
+		"sitemap.xml":                  "<loc>https://example.com/export-home/about/</loc>",
+		"res/freak-js.js":              "var freak=",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		} else if !strings.Contains(string(data), want) {
+			t.Errorf("%s: want: %q\ngot: %q", name, want, data)
+		}
+
+		if _, err = os.Stat(filepath.Join(dir, name+".gz")); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "export-missing")); !os.IsNotExist(err) {
+		t.Errorf("a failed page was exported: %v", err)
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	var newSrv = func() *server {
+		s, err := newServer("", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return (*server)(s)
+	}
+
+	var dir = t.TempDir()
+
+	for _, config := range []ExportConfig{
+		{Dir: dir},
+		{Dir: dir, BaseURL: "/no-host"},
+	} {
+		if _, err := newSrv().export(config); err == nil {
+			t.Errorf("%+v: expected an error", config)
+		}
+	}
+
+	var withCSP = newSrv()
+	if err := withCSP.setContentSecurityPolicy(CSPConfig{Policy: "script-src 'self'"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var withCSRF = newSrv()
+	if err := withCSRF.setSecretKey(bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := withCSRF.setCSRF(CSRFConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range map[string]*server{"csp": withCSP, "csrf": withCSRF} {
+		if _, err := s.export(ExportConfig{Dir: dir, BaseURL: "https://example.com"}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPageTemplates(t *testing.T) {
+	var page = NewPage(
+		Head[string]{Template: []HeadMarker[string]{{Static: "one"}, {Static: "two"}}},
+		nil,
+		func(*RouteResponse, string) {},
+	)
+
+	var body = serveTestRoute(t, page.Route(RouteData{Path: "/"}, func(*RouteResponse) (string, error) {
+		return "", nil
+	}))
+
+	if !strings.Contains(body, "<template>one</template><template>two</template>") {
+		t.Errorf("templates: got: %q", body)
+	}
+}
+
+func TestWrapperEnding(t *testing.T) {
+	var wrapper = NewComponent[string](CSS(""), JS(""),
+		HTML(`<section><!-- freak-wrapped-content --></section>`, None),
+	)
+
+	var comp = NewComponent(CSS(""), JS(""),
+		HTML(`<div data-freak="wrapped">text</div><p data-freak="name"></p>`, None),
+		Marker[string]{Name: "wrapped", Positions: Positions[string]{Pre[string](func(r *response[string], s string) {
+			r.insert(wrapper.component, s, nil)
+		})}},
+		Marker[string]{Name: "name", Positions: Positions[string]{Post[string](func(r *response[string], s string) {
+			r.WriteString(s)
+		})}},
+	)
+
+	if body, want := renderTestComponent(comp, "x"), `<section><div>text</div></section><p>x</p>`; body != want {
+		t.Errorf("want: %q\ngot: %q", want, body)
+	}
+}
+
+// serveTestRoute serves a GET request for the route's path on a new server,
+// and returns the response body.
+func serveTestRoute(t *testing.T, route Route) string {
+	var code, body = serveTestRouteStatus(t, route)
+
+	if code != http.StatusOK {
+		t.Fatalf("status: want: %d, got: %d", http.StatusOK, code)
+	}
+
+	return body
+}
+
+// serveTestRouteStatus is serveTestRoute for any status code.
+func serveTestRouteStatus(t *testing.T, route Route) (int, string) {
+	var rec = serveTestRequest(newTestServer(t, route), httptest.NewRequest(http.MethodGet, route.Path, nil))
+
+	return rec.Code, rec.Body.String()
+}
+
+// newTestServer makes a server with the routes, that isn't started.
+func newTestServer(t *testing.T, routes ...Route) *server {
+	s, err := newServer("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var srv = (*server)(s)
+	if err = srv.setRoutes(routes); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func serveTestRequest(s *server, req *http.Request) *httptest.ResponseRecorder {
+	var rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+// testJar keeps the cookies of one client between requests.
+type testJar map[string]*http.Cookie
+
+func (j testJar) serve(s *server, req *http.Request) *httptest.ResponseRecorder {
+	for _, c := range j {
+		req.AddCookie(c)
+	}
+
+	var rec = serveTestRequest(s, req)
+
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(j, c.Name)
+		} else {
+			j[c.Name] = c
+		}
+	}
+	return rec
+}
+
+func (j testJar) get(s *server, target string) string {
+	return j.serve(s, httptest.NewRequest(http.MethodGet, target, nil)).Body.String()
+}
@@ -2,6 +2,7 @@ package freak
 
 import (
 	"bytes"
+	"math"
 	"regexp"
 	"strings"
 
@@ -30,10 +31,15 @@ func (hcf htmlFlagHolder) isZero() bool {
 
 type html struct {
 	in, out string
+	name    string  // of the file, for compile errors
+	src     *source // for dev mode
 	compId  string
 	level   htmlFlagHolder
 }
 
+// maxHTMLSize is the largest compiled HTML whose offsets fit in the markers.
+const maxHTMLSize = math.MaxUint32
+
 const dataFreakAttr = "data-freak"
 const dataFreakJSAttr = "data-freak-js"
 
@@ -53,12 +59,19 @@ const (
 	Extreme    = compressComments | compressWhitespace | compressAttrQuotes | compressEndTags | compressStartTags | compressWhitespaceExtreme
 )
 
-func (c *component[T]) processHTML(htmlIn string, compressionLevel htmlFlagHolder, markers []Marker[T]) {
+// processHTML compiles the HTML with its markers. All problems that are found
+// are returned in a *CompileError.
+func (c *component[T]) processHTML(
+	name, htmlIn string, compressionLevel htmlFlagHolder, markers []Marker[T],
+) error {
 	if len(c.html) != 0 {
-		return
+		return nil
 	}
 	c.html = []byte(htmlIn)
 
+	c.compiling = newCompiler(name, htmlIn)
+	defer func() { c.compiling = nil }()
+
 	var ctxNode = getContext(strToBytes(htmlIn))
 	var node *html_parser.Node
 	var nodes []*html_parser.Node
@@ -83,8 +96,11 @@ func (c *component[T]) processHTML(htmlIn string, compressionLevel htmlFlagHolde
 		}
 	}
 
+	c.compiling.locate(node)
+
 	if err != nil {
-		panic(err)
+		c.compiling.add(c.compiling.endOffset, "%s", err)
+		return c.compiling.err()
 	}
 
 	// If comments are to be removed, we do it first so that newly adjacent text
@@ -98,10 +114,25 @@ func (c *component[T]) processHTML(htmlIn string, compressionLevel htmlFlagHolde
 		compressSpace(node, c.compressionLevel.hasAny(compressWhitespaceExtreme))
 	}
 
+	var um = newUserMarkers(c.compiling, markers)
+
 	var buf bytes.Buffer
-	c.render(node, &buf, true, markers)
+	c.render(node, &buf, true, um)
+
+	um.checkUsed(c.compiling)
+
+	if uint64(buf.Len()) > maxHTMLSize {
+		var offset = c.compiling.overflowOffset
+		if offset == -1 { // It grew too large in the last node
+			offset = len(htmlIn)
+		}
+
+		c.compiling.add(offset, "the compiled HTML is %d bytes, but can be at most %d", buf.Len(), maxHTMLSize)
+	}
 
 	c.html = buf.Bytes()
+
+	return c.compiling.err()
 }
 
 var reTag = regexp.MustCompile(`(?i)<(!--|!doctype|[a-z][a-z0-9]*)`)
@@ -384,6 +415,24 @@ func nodeIsOneOf(n *html_parser.Node, atoms ...atom.Atom) bool {
 	return false
 }
 
+// isStylesheetLink reports if the node is a <link rel=stylesheet>, which the
+// style-src directive of a CSP applies to.
+func isStylesheetLink(n *html_parser.Node) bool {
+	if n.DataAtom != atom.Link {
+		return false
+	}
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, "rel") {
+			for _, rel := range strings.Fields(attr.Val) {
+				if strings.EqualFold(rel, "stylesheet") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func removeNode(n *html_parser.Node) (prev, next *html_parser.Node) {
 	prev, next = n.PrevSibling, n.NextSibling
 
@@ -0,0 +1,214 @@
+package freak
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	_retryAfter                = "Retry-After"
+	_defaultLimiterIdleExpiry  = 10 * time.Minute
+	_concurrencyRetryAfterSecs = "1"
+)
+
+// ErrRateLimited is given to the 429 error page when a client exceeded its rate.
+var ErrRateLimited = errors.New("too many requests")
+
+// ErrTooBusy is given to the 503 error page when the cap on concurrent
+// renders was reached.
+var ErrTooBusy = errors.New("too many concurrent renders")
+
+// Rate is a token bucket that allows `Requests` per `Per` on average, and
+// bursts of up to `Burst` requests. Burst defaults to Requests.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// RateLimits configures the limits of a Server. Zero values disable a limit.
+type RateLimits struct {
+	// PerClient limits the requests of each client IP to the whole server,
+	// including static files. Trusted proxies are looked past.
+	PerClient Rate
+
+	// MaxConcurrentRenders caps the number of pages rendered at once. Requests
+	// beyond it get a 503 instead of waiting.
+	MaxConcurrentRenders int
+
+	// IdleExpiry is how long the state of an idle client is kept. It defaults
+	// to 10 minutes.
+	IdleExpiry time.Duration
+}
+
+// SetRateLimits enables the rate and concurrency limits. A client that is
+// over its rate gets a 429 with a Retry-After header. Requests without a
+// client IP, like those over a unix socket, are not rate limited. Routes can
+// add their own per-client limit with Route.RateLimit, and it may be called
+// before or after SetRoutes.
+func (s *Server) SetRateLimits(limits RateLimits) error {
+	return (*server)(s).setRateLimits(limits)
+}
+
+func (s *server) setRateLimits(limits RateLimits) error {
+	if s.isStarted {
+		return fmt.Errorf("Server is already running")
+	}
+
+	if limits.IdleExpiry <= 0 {
+		limits.IdleExpiry = _defaultLimiterIdleExpiry
+	}
+	s.limiterIdleExpiry = limits.IdleExpiry
+
+	// Routes that were already set get the expiry too
+	for _, fh := range s.routes {
+		if fh.limiter != nil {
+			fh.limiter.idleExpiry = limits.IdleExpiry
+		}
+	}
+
+	if limits.PerClient.Requests > 0 {
+		var err error
+		if s.clientLimiter, err = newRateLimiter(limits.PerClient, limits.IdleExpiry); err != nil {
+			return err
+		}
+	}
+
+	if limits.MaxConcurrentRenders > 0 {
+		s.renderSlots = make(chan struct{}, limits.MaxConcurrentRenders)
+	}
+
+	return nil
+}
+
+type rateLimiter struct {
+	rate       float64 // tokens per second
+	burst      float64
+	idleExpiry time.Duration
+
+	mux       sync.Mutex
+	buckets   map[netip.Addr]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(r Rate, idleExpiry time.Duration) (*rateLimiter, error) {
+	if r.Requests <= 0 || r.Per <= 0 {
+		return nil, fmt.Errorf("Rate needs positive Requests and Per values")
+	}
+	if r.Burst <= 0 {
+		r.Burst = r.Requests
+	}
+
+	return &rateLimiter{
+		rate:       float64(r.Requests) / r.Per.Seconds(),
+		burst:      float64(r.Burst),
+		idleExpiry: idleExpiry,
+		buckets:    map[netip.Addr]*bucket{},
+		lastSweep:  time.Now(),
+	}, nil
+}
+
+// allow takes a token from the client's bucket. If there is none, it returns
+// how long until the next token is available.
+func (l *rateLimiter) allow(client netip.Addr, now time.Time) (bool, time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	// Idle clients are swept on the way in, at most once per expiry period
+	if now.Sub(l.lastSweep) > l.idleExpiry {
+		l.lastSweep = now
+
+		for addr, b := range l.buckets {
+			if now.Sub(b.last) > l.idleExpiry {
+				delete(l.buckets, addr)
+			}
+		}
+	}
+
+	var b = l.buckets[client]
+
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// checkRate reports if the request is within the limiter's rate. If not, a
+// 429 is sent. Clients without a valid IP can't be told apart, so they are
+// let through rather than share one bucket.
+func (s *server) checkRate(
+	l *rateLimiter, resp http.ResponseWriter, req *http.Request,
+) bool {
+	if l == nil {
+		return true
+	}
+
+	var client = s.remoteIP(req)
+	if !client.IsValid() {
+		return true
+	}
+
+	var ok, wait = l.allow(client, time.Now())
+	if ok {
+		return true
+	}
+
+	resp.Header()[_retryAfter] = []string{retryAfterSeconds(wait)}
+
+	s.serveError(resp, req, http.StatusTooManyRequests, ErrRateLimited)
+	return false
+}
+
+// retryAfterSeconds rounds up, so that the client never retries too early.
+func retryAfterSeconds(d time.Duration) string {
+	var secs = int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
+// acquireRender takes one of the render slots, if there is a cap. It returns
+// false if they're all taken.
+func (s *server) acquireRender() bool {
+	if s.renderSlots == nil {
+		return true
+	}
+
+	select {
+	case s.renderSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *server) releaseRender() {
+	if s.renderSlots != nil {
+		<-s.renderSlots
+	}
+}
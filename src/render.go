@@ -0,0 +1,53 @@
+package freak
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// Render renders the component with the data to `w`, without a server or a
+// request, as for emails, background jobs or tests. Callbacks that read the
+// request get empty values.
+func Render[T any](w io.Writer, c Component[T], data T) error {
+	var buf, err = render(c, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// RenderGzip is Render with the output compressed by gzip, at the given level
+// of the compress/gzip package.
+func RenderGzip[T any](w io.Writer, c Component[T], data T, level int) error {
+	var gz, err = gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return err
+	}
+
+	buf, err := render(c, data)
+	if err != nil {
+		return err
+	}
+
+	if _, err = gz.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func render[T any](c Component[T], data T) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	var r = response[T]{&responseCore{writer: &buf}}
+
+	var locked = lockRender()
+	r.insert(c.component, data, nil)
+	unlockRender(locked)
+
+	if r.responseState.has(failed) {
+		return nil, r.err
+	}
+	return &buf, nil
+}
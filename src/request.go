@@ -0,0 +1,200 @@
+package freak
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// SetTrustedProxies sets the addresses, in CIDR notation, of the proxies whose
+// X-Forwarded-For header is trusted when finding a client's RemoteIP.
+func (s *Server) SetTrustedProxies(cidrs ...string) error {
+	return (*server)(s).setTrustedProxies(cidrs)
+}
+
+func (s *server) setTrustedProxies(cidrs []string) error {
+	if s.isStarted {
+		return fmt.Errorf("Server is already running")
+	}
+
+	var prefixes = make([]netip.Prefix, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		var prefix, err = netip.ParsePrefix(cidr)
+		if err != nil {
+			// Allow a bare address for a single proxy
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return err
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	s.trustedProxies = prefixes
+	return nil
+}
+
+func (s *server) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP finds the client's address. If the connection is from a trusted
+// proxy, the X-Forwarded-For entries are walked from the nearest hop, and the
+// first untrusted address is the client.
+func (s *server) remoteIP(req *http.Request) netip.Addr {
+	var addr = peerAddr(req.RemoteAddr)
+
+	if s == nil || !addr.IsValid() || !s.isTrustedProxy(addr) {
+		return addr
+	}
+
+	var forwarded = req.Header.Values("X-Forwarded-For")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		var hops = strings.Split(forwarded[i], ",")
+
+		for j := len(hops) - 1; j >= 0; j-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[j]))
+			if err != nil {
+				return addr // Malformed, so the last good address is used
+			}
+
+			addr = hop.Unmap()
+
+			if !s.isTrustedProxy(addr) {
+				return addr
+			}
+		}
+	}
+
+	return addr
+}
+
+// peerAddr parses the address of the connection's other end.
+func peerAddr(remoteAddr string) netip.Addr {
+	var host, _, err = net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// Method returns the request's method.
+func (r *response[T]) Method() string {
+	if r.req == nil { // Rendered outside of HTTP
+		return ""
+	}
+	return r.req.Method
+}
+
+// URL returns a copy of the request's parsed URL.
+func (r *response[T]) URL() url.URL {
+	if r.req == nil {
+		return url.URL{}
+	}
+	return *r.req.URL
+}
+
+// Query returns the first value of the query parameter `key`, or an empty
+// string.
+func (r *response[T]) Query(key string) string {
+	if vals := r.queryValues()[key]; len(vals) != 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// QueryValues returns a copy of all values of the query parameter `key`.
+func (r *response[T]) QueryValues(key string) []string {
+	return append([]string(nil), r.queryValues()[key]...)
+}
+
+// queryValues parses the query once per request.
+func (r *response[T]) queryValues() url.Values {
+	if r.query == nil && r.req != nil {
+		r.query = r.req.URL.Query()
+	}
+	return r.query
+}
+
+// Header returns the first value of the request header `key`, or an empty
+// string.
+func (r *response[T]) Header(key string) string {
+	if r.req == nil {
+		return ""
+	}
+	return r.req.Header.Get(key)
+}
+
+// HeaderValues returns a copy of all values of the request header `key`.
+func (r *response[T]) HeaderValues(key string) []string {
+	if r.req == nil {
+		return nil
+	}
+	return append([]string(nil), r.req.Header.Values(key)...)
+}
+
+// RemoteIP returns the client's address, looking past any trusted proxies.
+// It is the zero Addr if the address could not be parsed.
+func (r *response[T]) RemoteIP() netip.Addr {
+	if r.req == nil {
+		return netip.Addr{}
+	}
+	return r.server.remoteIP(r.req)
+}
+
+// Method returns the request's method.
+func (r *RouteResponse) Method() string {
+	return r.r.Method()
+}
+
+// URL returns a copy of the request's parsed URL.
+func (r *RouteResponse) URL() url.URL {
+	return r.r.URL()
+}
+
+// Query returns the first value of the query parameter `key`, or an empty
+// string.
+func (r *RouteResponse) Query(key string) string {
+	return r.r.Query(key)
+}
+
+// QueryValues returns a copy of all values of the query parameter `key`.
+func (r *RouteResponse) QueryValues(key string) []string {
+	return r.r.QueryValues(key)
+}
+
+// Header returns the first value of the request header `key`, or an empty
+// string.
+func (r *RouteResponse) Header(key string) string {
+	return r.r.Header(key)
+}
+
+// HeaderValues returns a copy of all values of the request header `key`.
+func (r *RouteResponse) HeaderValues(key string) []string {
+	return r.r.HeaderValues(key)
+}
+
+// RemoteIP returns the client's address, looking past any trusted proxies.
+// It is the zero Addr if the address could not be parsed.
+func (r *RouteResponse) RemoteIP() netip.Addr {
+	return r.r.RemoteIP()
+}
@@ -3,10 +3,13 @@ package freak
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"runtime"
+	"time"
 )
 
 type state[T responseStateFlag | componentStateFlag] struct {
@@ -36,6 +39,9 @@ const (
 	cacheTail
 	allStatic
 	allSkip
+	skipCache
+	failed   // an error page is pending
+	inStatic // a Static callback runs, when the component is compiled
 )
 
 type componentStateFlag uint8
@@ -56,29 +62,92 @@ type responseBase[T any] struct {
 	// the underlying Writer for the gzip.Writer
 	gzip gzip.Writer
 
+	core responseCore
+
 	response[T]
 }
 
-// Response is the part that we actually pass through to the components
+// Response is the part that we actually pass through to the components. None
+// of its state depends on T, so it's kept in a responseCore that is shared
+// with the responses of children that have another data type.
 type response[T any] struct {
-	// This receives either the &buf or the &gzip from this struct.
+	*responseCore
+}
+
+type responseCore struct {
+	// This receives either the &buf or the &gzip from responseBase.
 	// ONLY write to this writer, not to 'buf' or 'gzip'
 	writer io.Writer
 
 	siteMapNode *SiteMapNode // for the requested page
 
-	quickZero[T]
+	// typed holds the responses for other data types that share this state,
+	// so that inserting a child doesn't allocate every time.
+	typed []any
+
+	quickZero
 }
 
-type quickZero[T any] struct {
+type quickZero struct {
 	cookiesToSend  []*http.Cookie
+	session        *Session
+	flash          flashState
+	csrfToken      string
+	nonce          string
+	cacheTags      []string
 	wrapperEndings func()
+	item           ListItem
+	slots          *slotSet
+	server         *server
 	resp           http.ResponseWriter
 	req            *http.Request
+	query          url.Values
+	ctx            context.Context
+	done           <-chan struct{}
+	status         int
+	err            error
 	responseState  state[responseStateFlag]
 	componentState state[componentStateFlag]
 }
 
+// Context returns the context of the request. It is done when the client
+// disconnects, or when the route's RenderTimeout runs out.
+func (r *response[T]) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// setContext sets the context, and keeps its Done channel for the cheap
+// check between markers.
+func (r *response[T]) setContext(ctx context.Context) {
+	r.ctx = ctx
+	r.done = ctx.Done()
+}
+
+// isDone reports if the context was cancelled or timed out. If so, the
+// response fails so that no more rendering takes place.
+func (r *response[T]) isDone() bool {
+	select {
+	case <-r.done:
+		r.fail(http.StatusServiceUnavailable, r.ctx.Err())
+		return true
+	default:
+		return false
+	}
+}
+
+// fail stores the error page to send in place of the rendered content. Only
+// the first failure is kept.
+func (r *response[T]) fail(code int, err error) {
+	if r.responseState.hasAny(sent | failed) {
+		return
+	}
+	r.responseState.set(failed)
+	r.status, r.err = code, err
+}
+
 func (r *response[T]) SkipElement() {
 	r.componentState.set(skipElement)
 }
@@ -119,6 +188,7 @@ var (
 	bytSpaceUnderscoreEqualDblQuote = []byte(` _="`)
 	bytSpace                        = []byte{' '}
 	bytEqualDblQuote                = []byte{'=', '"'}
+	bytQuote                        = []byte{'"'}
 )
 
 func (r AttrResponse[T]) AddAttr(key, val string) {
@@ -143,7 +213,7 @@ func writeAttr[T any](w io.Writer, key, val string, escape bool) {
 
 WRITE_VAL:
 	writeUnDoubleQuote(w, val)
-	w.Write(bytDblQuot)
+	w.Write(bytQuote)
 }
 
 func (r AttrResponse[T]) SkipContent() {
@@ -197,12 +267,15 @@ func getResponse(
 			gzip: *gz,
 			buf:  *bytes.NewBuffer(make([]byte, 0, _bufMaxSize)),
 		}
+		r.responseCore = &r.core
 	}
 
 INITIALIZE:
+	r.server = s
 	r.req = req
 	r.resp = resp
 	r.siteMapNode = node
+	r.setContext(req.Context())
 
 	if doGzip {
 		r.responseState.set(acceptsGzip)
@@ -216,18 +289,58 @@ INITIALIZE:
 	return r
 }
 
-// putResponse puts the *Response object back in the pool.
-func putResponse(s *server, r *responseBase[*RouteData]) {
-	if !r.responseState.has(sent) {
-		r.resp.WriteHeader(http.StatusOK)
+// send writes the buffered page to the client, unless a response was already
+// sent. When `tagging` enables ETags, the body is hashed first, and a request
+// whose If-None-Match matches the tag receives a 304 without the body.
+func (r *responseBase[T]) send(tagging ETag) {
+	if r.responseState.has(sent) {
+		return
+	}
+	r.responseState.set(sent)
 
-		if r.responseState.has(acceptsGzip) {
-			r.gzip.Close()
-		}
+	r.commit()
 
-		r.resp.Write(r.buf.Bytes())
+	if r.responseState.has(acceptsGzip) {
+		r.gzip.Close()
 	}
 
+	var body = r.buf.Bytes()
+
+	if r.status != 0 && r.status != http.StatusOK {
+		r.resp.WriteHeader(r.status)
+		r.resp.Write(body)
+		return
+	}
+
+	var tag string
+
+	if tagging != NoETag {
+		tag = tagging.tag(body)
+	}
+
+	sendBody(r.resp, r.req, body, tag)
+}
+
+// sendBody writes a complete page body with a StatusOK, or a 304 if `tag` is
+// not empty and is matched by the request's If-None-Match header.
+func sendBody(resp http.ResponseWriter, req *http.Request, body []byte, tag string) {
+	if len(tag) != 0 {
+		resp.Header()[_eTag] = []string{tag}
+
+		if isSafeMethod(req.Method) && eTagMatches(req.Header.Get(_ifNoneMatch), tag) {
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(body)
+}
+
+// putResponse puts the *Response object back in the pool.
+func putResponse(s *server, r *responseBase[*RouteData]) {
+	r.send(NoETag)
+
 	r.buf.Reset()
 
 	if r.buf.Cap() > _bufMaxSize {
@@ -236,8 +349,9 @@ func putResponse(s *server, r *responseBase[*RouteData]) {
 	}
 
 	// Clear data and put back into the pool.
-	r.quickZero = quickZero[*RouteData]{
+	r.quickZero = quickZero{
 		cookiesToSend: r.cookiesToSend[0:0],
+		cacheTags:     r.cacheTags[0:0],
 	}
 
 	if _poolEnabled {
@@ -250,8 +364,26 @@ func putResponse(s *server, r *responseBase[*RouteData]) {
 	}
 }
 
+// asResponse returns a response for the data type U that shares the state of
+// `r`. It is made once per type, and kept for the next time.
+func asResponse[U, T any](r *response[T]) *response[U] {
+	if u, ok := any(r).(*response[U]); ok {
+		return u
+	}
+
+	for _, t := range r.typed {
+		if u, ok := t.(*response[U]); ok {
+			return u
+		}
+	}
+
+	var u = &response[U]{r.responseCore}
+	r.typed = append(r.typed, u)
+	return u
+}
+
 func (r *response[T]) insert(c *component[T], data T, newlyReceivedEndings []wrapperEndingAndIndex) {
-	if c == nil || r.responseState.has(sent) {
+	if c == nil || r.responseState.hasAny(sent|failed) || r.isDone() {
 		return
 	}
 
@@ -276,16 +408,20 @@ func (r *response[T]) insert(c *component[T], data T, newlyReceivedEndings []wra
 
 		r.componentState.flags = flags
 
-		if isWrapper {
+		// Once its second half is done, a wrapper leaves no ending behind
+		if isWrapper && !doingWrapperEnding {
+
+			// A copy is captured, so that only wrappers move the endings to the heap
+			var endings = newlyReceivedEndings
 
 			r.wrapperEndings = func() { // <-- Callback to execute this wrapper's ending
 
-				if newlyReceivedEndings == nil {
+				if endings == nil {
 					// Must not be 'nil'; it indicates that we're doing the second part of a wrapper
-					newlyReceivedEndings = []wrapperEndingAndIndex{}
+					endings = []wrapperEndingAndIndex{}
 				}
 
-				r.insert(c, data, newlyReceivedEndings) // Completes the second half of the wrapper
+				r.insert(c, data, endings) // Completes the second half of the wrapper
 
 				if prevAdjacentEndings != nil {
 					prevAdjacentEndings() // If there were adjacent wrappers applied, the next one is completed
@@ -299,7 +435,7 @@ func (r *response[T]) insert(c *component[T], data T, newlyReceivedEndings []wra
 	}(r.wrapperEndings, r.componentState.flags)
 	// ----^^^^^^------------^^^^^^--- capture their current value
 
-	var htmlIndex uint16 = 0
+	var htmlIndex uint32 = 0
 
 	r.wrapperEndings = nil
 
@@ -307,7 +443,7 @@ func (r *response[T]) insert(c *component[T], data T, newlyReceivedEndings []wra
 	//	the start of a new marker, the end of a component or first half of a wrapper. It
 	//	checks for any receved "wrapper endings" in the section of HTML being written, and
 	//	if found, it executes those endings.
-	var tryEndings = func(target uint16) {
+	var tryEndings = func(target uint32) {
 
 		for htmlIndex < mostRecentEnding.index && mostRecentEnding.index < target {
 			r.writer.Write(c.html[htmlIndex:mostRecentEnding.index])
@@ -376,8 +512,20 @@ func (r *response[T]) insert(c *component[T], data T, newlyReceivedEndings []wra
 
 	// Iterate the markers of the current component (or perhaps part, if it's a wrapper)
 	for ; markerIndex < markerEndIndex; markerIndex++ {
+		if r.responseState.has(failed) || r.isDone() {
+			return // Whatever was written is discarded for the error page
+		}
+
 		var m = c.markers[markerIndex]
 
+		if m.callbacks[preCallbackIndex].pos < htmlIndex {
+			continue // Nested in an element or content that was skipped
+		}
+
+		if m.nonceOnly && len(r.nonce) == 0 {
+			continue // No policy, so the tag stays as it was compiled
+		}
+
 		r.wrapperEndings = nil
 		r.componentState.flags = 0
 
@@ -385,7 +533,7 @@ func (r *response[T]) insert(c *component[T], data T, newlyReceivedEndings []wra
 
 		var contentMarkerWasRemoved bool
 
-		if r.responseState.has(skipElement) {
+		if r.componentState.has(skipElement) {
 			contentMarkerWasRemoved = m.containsWrapperContentMarker
 
 			htmlIndex = m.callbacks[preCallbackIndex].endPos
@@ -396,7 +544,7 @@ func (r *response[T]) insert(c *component[T], data T, newlyReceivedEndings []wra
 
 			callMarkerCallback(m.callbacks[postCallbackIndex], false)
 
-			if r.responseState.has(skipContent) {
+			if r.componentState.has(skipContent) {
 				contentMarkerWasRemoved = m.containsWrapperContentMarker
 
 				htmlIndex = m.callbacks[postCallbackIndex].endPos
@@ -411,7 +559,7 @@ func (r *response[T]) insert(c *component[T], data T, newlyReceivedEndings []wra
 	}
 
 	if finishComponent {
-		tryEndings(uint16(len(c.html)))
+		tryEndings(uint32(len(c.html)))
 
 	} else {
 		tryEndings(c.wrapperContentHTMLIndex)
@@ -419,17 +567,35 @@ func (r *response[T]) insert(c *component[T], data T, newlyReceivedEndings []wra
 }
 
 type RouteResponse struct {
-	r response[*RouteData]
+	r *response[*RouteData]
+}
+
+// CacheTags attaches tags to the page, when the route has an output cache, so
+// that it can be removed with Server.InvalidateCache.
+func (r *RouteResponse) CacheTags(tags ...string) {
+	r.r.cacheTags = append(r.r.cacheTags, tags...)
+}
+
+// SkipCache prevents the page from being stored in the route's output cache.
+func (r *RouteResponse) SkipCache() {
+	r.r.responseState.set(skipCache)
+}
+
+// Context returns the context of the request. It is done when the client
+// disconnects, or when the route's RenderTimeout runs out.
+func (r *RouteResponse) Context() context.Context {
+	return r.r.Context()
+}
+
+// SendError discards whatever was rendered, and responds with the error page
+// that was set for the `code`. Rendering stops at the next marker.
+func (r *RouteResponse) SendError(code int, err error) {
+	r.r.fail(code, err)
 }
 
 // Send503 sends a `StatusServiceUnavailable` response.
 func (r *RouteResponse) Send503(err error) {
-	fmt.Println(err)
-
-	if !r.r.responseState.has(sent) {
-		r.r.responseState.set(sent)
-		r.r.resp.WriteHeader(http.StatusServiceUnavailable)
-	}
+	r.SendError(http.StatusServiceUnavailable, err)
 }
 
 // Redirect sends a redirect with the given response `code` to the given `url`.
@@ -454,37 +620,63 @@ func (r *RouteResponse) PermanentRedirect(url string) {
 }
 
 func (r *RouteResponse) doRedirect(code int, url string) {
-	if r.r.responseState.has(sent) {
+	if r.r.responseState.hasAny(sent | failed) {
 		return
 	}
 	r.r.responseState.set(sent)
 
-	//		r.sendCookies()
+	r.r.commit()
 
 	http.Redirect(r.r.resp, r.r.req, url, code)
 }
 
-/*
 // SetCookie sets the given cookie to be sent with the response.
-func (br *baseResponse) SetCookie(c *http.Cookie) {
-	http.SetCookie(br.response, c)
+func (r *RouteResponse) SetCookie(c *http.Cookie) {
+	r.r.cookiesToSend = append(r.r.cookiesToSend, c)
+}
+
+// GetCookie gets the cookie from the current request, or `nil` if there is
+// no cookie with that name.
+func (r *RouteResponse) GetCookie(name string) *http.Cookie {
+	return r.r.getCookie(name)
 }
 
-// GetCookie gets the cookie from the current request.
-func (br *baseResponse) GetCookie(name string) *http.Cookie {
-	c, err := br.request.Cookie(name)
+// ExpireCookie expires the given cookie.
+func (r *RouteResponse) ExpireCookie(c *http.Cookie) {
+	var cc = *c
+	cc.MaxAge = -1
+	r.SetCookie(&cc)
+}
+
+func (r *response[T]) getCookie(name string) *http.Cookie {
+	if r.req == nil { // Rendered outside of HTTP
+		return nil
+	}
+
+	c, err := r.req.Cookie(name)
 	if err != nil && err != http.ErrNoCookie {
-		log.Printf("GetCookie error: %q\n", err)
+		fmt.Printf("GetCookie error: %q\n", err)
 	}
 
 	return c // Return the Cookie or nil
 }
 
-// ExpireCookie expires the given cookie.
-func (br *baseResponse) ExpireCookie(c *http.Cookie) {
-	cc := *c
-	cc.MaxAge = -1
-	http.SetCookie(br.response, &cc)
-}
+// commit saves the flashes and the session, and moves the pending cookies to
+// the headers. It must be called before the header is written, and is a no-op
+// when repeated.
+func (r *response[T]) commit() {
+	r.commitFlashes()
+
+	if r.session != nil {
+		if c := r.session.commit(time.Now()); c != nil {
+			r.cookiesToSend = append(r.cookiesToSend, c)
+		}
+		r.session = nil
+	}
 
-*/
+	for _, c := range r.cookiesToSend {
+		http.SetCookie(r.resp, c)
+	}
+
+	r.cookiesToSend = r.cookiesToSend[0:0]
+}
@@ -0,0 +1,131 @@
+package freak
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const _minSecretKeyLen = 32
+
+// SetSecretKey sets the key that signs and encrypts the values freak stores
+// in cookies, like session IDs. It must be at least 32 random bytes, and
+// should be the same across restarts and across servers of the same site.
+func (s *Server) SetSecretKey(key []byte) error {
+	return (*server)(s).setSecretKey(key)
+}
+
+func (s *server) setSecretKey(key []byte) (err error) {
+	if s.isStarted {
+		return fmt.Errorf("Server is already running")
+	}
+
+	s.secret, err = newSecret(key)
+	return err
+}
+
+type secret struct {
+	signKey []byte
+	aead    cipher.AEAD
+}
+
+func newSecret(key []byte) (*secret, error) {
+	if len(key) < _minSecretKeyLen {
+		return nil, fmt.Errorf("secret key must be at least %d bytes", _minSecretKeyLen)
+	}
+
+	// Separate keys are derived, so that no key is used for two purposes
+	var derive = func(label string) []byte {
+		var mac = hmac.New(sha256.New, key)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+
+	block, err := aes.NewCipher(derive("freak-encrypt"))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &secret{signKey: derive("freak-sign"), aead: aead}, nil
+}
+
+// sign returns the `value` followed by a MAC over the value and its purpose.
+// The purpose, which is usually the cookie name, prevents the signed value
+// from being accepted in a different place.
+func (s *secret) sign(purpose, value string) string {
+	return value + "." + base64.RawURLEncoding.EncodeToString(s.mac(purpose, value))
+}
+
+// verify returns the value of a string created by `sign`, if its MAC is valid.
+func (s *secret) verify(purpose, signed string) (string, bool) {
+	var idx = strings.LastIndexByte(signed, '.')
+	if idx == -1 {
+		return "", false
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signed[idx+1:])
+	if err != nil || !hmac.Equal(mac, s.mac(purpose, signed[0:idx])) {
+		return "", false
+	}
+
+	return signed[0:idx], true
+}
+
+func (s *secret) mac(purpose, value string) []byte {
+	var mac = hmac.New(sha256.New, s.signKey)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// encrypt seals the `value` so that it can neither be read nor altered.
+func (s *secret) encrypt(purpose, value string) string {
+	var nonce = make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(value)+s.aead.Overhead())
+
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // The system's randomness is broken
+	}
+
+	var sealed = s.aead.Seal(nonce, nonce, []byte(value), []byte(purpose))
+
+	return base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// decrypt returns the value of a string created by `encrypt`.
+func (s *secret) decrypt(purpose, encrypted string) (string, bool) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", false
+	}
+
+	var nonce, ciphertext = sealed[0:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+
+	value, err := s.aead.Open(nil, nonce, ciphertext, []byte(purpose))
+	if err != nil {
+		return "", false
+	}
+
+	return string(value), true
+}
+
+// randomString returns `n` random bytes, encoded for use in URLs and cookies.
+func randomString(n int) string {
+	var b = make([]byte, n)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // The system's randomness is broken
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,193 @@
+package freak
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_hsts              = "Strict-Transport-Security"
+	_contentTypeOpts   = "X-Content-Type-Options"
+	_referrerPolicy    = "Referrer-Policy"
+	_frameOptions      = "X-Frame-Options"
+	_permissionsPolicy = "Permissions-Policy"
+)
+
+// FrameOptions selects who may show a page in a frame.
+type FrameOptions uint8
+
+const (
+	// FrameAny sends no framing restriction.
+	FrameAny = FrameOptions(iota)
+
+	// FrameDeny forbids framing altogether.
+	FrameDeny
+
+	// FrameSameOrigin only allows framing by pages of the same origin.
+	FrameSameOrigin
+)
+
+// SecurityHeaders is the set of security related headers that is sent with
+// every response, including the generated CSS and JS, and static files. The
+// zero value sends none of them.
+type SecurityHeaders struct {
+	// HSTSMaxAge sends Strict-Transport-Security with this max-age. It is
+	// only sent over TLS, or when a trusted proxy forwards an https request.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// NoSniff sends `X-Content-Type-Options: nosniff`.
+	NoSniff bool
+
+	// ReferrerPolicy is sent unless it's SkipReferrer.
+	ReferrerPolicy Referrer
+
+	// Frame sends both X-Frame-Options and the frame-ancestors directive.
+	Frame FrameOptions
+
+	// FrameAncestors, if set, replaces the frame-ancestors sources that are
+	// derived from Frame, for example "'self' https://partner.example". Since
+	// X-Frame-Options can't express a list, it is not sent in that case.
+	FrameAncestors string
+
+	// PermissionsPolicy is sent if not empty, for example "camera=(), geolocation=()".
+	PermissionsPolicy string
+}
+
+// DefaultSecurityHeaders returns a recommended set for SetSecurityHeaders. It
+// includes HSTS, which browsers remember for a year. A new Server sends none.
+func DefaultSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		HSTSMaxAge:     365 * 24 * time.Hour,
+		NoSniff:        true,
+		ReferrerPolicy: StritOriginWhenCrossOrigin,
+		Frame:          FrameSameOrigin,
+	}
+}
+
+// SetSecurityHeaders sets the server's security headers, which are off by
+// default. Routes can replace them with Route.SecurityHeaders.
+func (s *Server) SetSecurityHeaders(h SecurityHeaders) error {
+	return (*server)(s).setSecurityHeaders(h)
+}
+
+func (s *server) setSecurityHeaders(h SecurityHeaders) error {
+	if s.isStarted {
+		return fmt.Errorf("Server is already running")
+	}
+
+	s.security = h.compile()
+	return nil
+}
+
+// securityHeaders holds the header values, built once. Every slice has its
+// capacity limited, so that appending to a response header never writes into
+// the shared array.
+type securityHeaders struct {
+	header http.Header
+	hsts   []string
+}
+
+func (h SecurityHeaders) compile() *securityHeaders {
+	var sh = securityHeaders{header: http.Header{}}
+
+	var set = func(key, val string) {
+		sh.header[key] = []string{val}[0:1:1]
+	}
+
+	if h.HSTSMaxAge > 0 {
+		var val = "max-age=" + strconv.FormatInt(int64(h.HSTSMaxAge/time.Second), 10)
+
+		if h.HSTSIncludeSubdomains {
+			val += "; includeSubDomains"
+		}
+		if h.HSTSPreload {
+			val += "; preload"
+		}
+
+		sh.hsts = []string{val}[0:1:1]
+	}
+
+	if h.NoSniff {
+		set(_contentTypeOpts, "nosniff")
+	}
+
+	if h.ReferrerPolicy != SkipReferrer {
+		set(_referrerPolicy, h.ReferrerPolicy.String())
+	}
+
+	var ancestors = h.FrameAncestors
+
+	switch h.Frame {
+	case FrameDeny:
+		if len(ancestors) == 0 {
+			set(_frameOptions, "DENY")
+			ancestors = "'none'"
+		}
+	case FrameSameOrigin:
+		if len(ancestors) == 0 {
+			set(_frameOptions, "SAMEORIGIN")
+			ancestors = "'self'"
+		}
+	}
+
+	if len(ancestors) != 0 {
+		set(_csp, "frame-ancestors "+ancestors)
+	}
+
+	if len(h.PermissionsPolicy) != 0 {
+		set(_permissionsPolicy, h.PermissionsPolicy)
+	}
+
+	return &sh
+}
+
+// apply sets the headers on the response.
+func (sh *securityHeaders) apply(s *server, resp http.ResponseWriter, req *http.Request) {
+	if sh == nil {
+		return
+	}
+
+	var respHdrs = resp.Header()
+
+	for key, val := range sh.header {
+		respHdrs[key] = val
+	}
+
+	if sh.hsts != nil && s.isHTTPS(req) {
+		respHdrs[_hsts] = sh.hsts
+	}
+}
+
+// replace removes the headers that `prev` set, and applies these instead.
+func (sh *securityHeaders) replace(prev *securityHeaders, s *server, resp http.ResponseWriter, req *http.Request) {
+	if prev != nil {
+		var respHdrs = resp.Header()
+
+		for key := range prev.header {
+			delete(respHdrs, key)
+		}
+		delete(respHdrs, _hsts)
+	}
+
+	sh.apply(s, resp, req)
+}
+
+// isHTTPS reports if the client connected with TLS, either directly or to a
+// trusted proxy.
+func (s *server) isHTTPS(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
+
+	if len(s.trustedProxies) == 0 {
+		return false
+	}
+
+	return s.isTrustedProxy(peerAddr(req.RemoteAddr)) &&
+		strings.EqualFold(req.Header.Get("X-Forwarded-Proto"), "https")
+}
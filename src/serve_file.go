@@ -26,8 +26,9 @@ const (
 	_contentEncoding = "Content-Encoding"
 	_contentType     = "Content-Type"
 	_gzip            = "gzip"
-	// 	_eTag            = "Etag"
-	// 	_ifNoneMatch     = "If-None-Match"
+	_eTag            = "Etag"
+	_ifNoneMatch     = "If-None-Match"
+	_vary            = "Vary"
 	// 	_msie6           = "MSIE 6"
 	// 	_userAgent       = "User-Agent"
 
@@ -85,8 +86,9 @@ var fileExt = map[string]struct {
 }
 
 var (
-	htmlContentHeader = fileExt[".html"].mime
-	gzipHeader        = []string{_gzip}
+	htmlContentHeader    = fileExt[".html"].mime
+	gzipHeader           = []string{_gzip}
+	acceptEncodingHeader = []string{_acceptEncoding}
 )
 
 // // Serves a static file from the filesystem for the given path.
@@ -1,14 +1,18 @@
 package freak
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
+	"io"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	static "github.com/Perelandric/static-serve"
 )
@@ -26,6 +30,29 @@ type Route struct {
 	RouteData
 	Handler  func(*RouteResponse, *RouteData)
 	Catch404 bool
+
+	// ETag enables hashing of the rendered page so that conditional GET
+	// requests can be answered with a 304. It is disabled by default.
+	ETag ETag
+
+	// Cache enables the output cache for the route. It is disabled when nil,
+	// and when the server has a Content-Security-Policy.
+	Cache *Cache
+
+	// RenderTimeout limits how long the page may take to render. Rendering is
+	// aborted at the next marker, and the 503 error page is sent instead.
+	RenderTimeout time.Duration
+
+	// SkipCSRF exempts the route from the CSRF check.
+	SkipCSRF bool
+
+	// SecurityHeaders, if set, replaces the server's security headers for the
+	// route.
+	SecurityHeaders *SecurityHeaders
+
+	// RateLimit, if set, limits the requests of each client IP to the route,
+	// in addition to the server's limit.
+	RateLimit *Rate
 }
 
 type Server server
@@ -34,6 +61,12 @@ func (s *Server) SetRoutes(routes ...Route) error {
 	return (*server)(s).setRoutes(routes)
 }
 
+// InvalidateCache removes every cached page that was stored with at least
+// one of the given tags, from the output cache of every route.
+func (s *Server) InvalidateCache(tags ...string) {
+	(*server)(s).invalidateCache(tags)
+}
+
 func (s *Server) Start() error {
 	return (*server)(s).start()
 }
@@ -60,6 +93,22 @@ type server struct {
 	compressionLevel int
 	binaryPath       string // Path leading to the application binary's directory
 
+	errorPages map[int]ErrorPage
+
+	trustedProxies []netip.Prefix
+
+	secret   *secret
+	sessions *sessions
+	csrf     *CSRFConfig
+	csp      *contentSecurityPolicy
+	security *securityHeaders
+
+	clientLimiter     *rateLimiter
+	limiterIdleExpiry time.Duration
+	renderSlots       chan struct{}
+
+	dev *devServer
+
 	css, js *os.File
 
 	isStarted bool
@@ -140,6 +189,29 @@ func (s *server) setRoutes(routes []Route) error {
 			staticFilePath: "",
 		}
 
+		if route.Cache != nil {
+			if route.Cache.TTL <= 0 {
+				return fmt.Errorf("Cache for path %q needs a positive TTL", pth)
+			}
+			sh.cache = newOutputCache(*route.Cache, s.compressionLevel != 0)
+		}
+
+		if route.SecurityHeaders != nil {
+			sh.security = route.SecurityHeaders.compile()
+		}
+
+		if route.RateLimit != nil {
+			var idleExpiry = s.limiterIdleExpiry
+			if idleExpiry <= 0 {
+				idleExpiry = _defaultLimiterIdleExpiry
+			}
+
+			var err error
+			if sh.limiter, err = newRateLimiter(*route.RateLimit, idleExpiry); err != nil {
+				return fmt.Errorf("RateLimit for path %q: %w", pth, err)
+			}
+		}
+
 		sh.siteMapNode = newSiteMapNode(pth, &sh.route)
 
 		// TODO: will need scripts/css/whatever
@@ -182,6 +254,8 @@ func (s *server) setRoutePaths(pth string, fh *freakHandler) error {
 }
 
 func (s *server) writeCssAndJs() (err error) {
+	assetMux.Lock()
+	defer assetMux.Unlock()
 
 	var cssFullPath = filepath.Join(s.binaryPath, _cssInsertionPath)
 	var jsFullPath = filepath.Join(s.binaryPath, _jsInsertionPath)
@@ -209,6 +283,11 @@ func (s *server) writeCssAndJs() (err error) {
 		allJs.String(),
 		jslib,
 	)
+	if err != nil || s.dev == nil {
+		return err
+	}
+
+	_, err = io.WriteString(s.js, devReloadScript)
 	return err
 }
 
@@ -226,6 +305,18 @@ func (s *server) start() error {
 
 	s.isStarted = true
 
+	if s.sessions != nil {
+		go s.sessions.sweep()
+	}
+
+	if s.dev != nil {
+		fmt.Println("Dev mode is on; watching the component files")
+
+		if s.addDevServer() {
+			go watch(s.dev.interval)
+		}
+	}
+
 	fmt.Println("Starting server...")
 
 	fmt.Println("Working directory:", s.binaryPath)
@@ -247,6 +338,12 @@ type freakHandler struct {
 
 	siteMapNode *SiteMapNode
 
+	cache *outputCache
+
+	security *securityHeaders
+
+	limiter *rateLimiter
+
 	staticFilePath string
 
 	dataSmashRouteId int32
@@ -283,11 +380,22 @@ func pathNoTrailingSlash(pth string) string {
 func (s *server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	var urlPath = req.URL.Path
 
+	s.security.apply(s, resp, req)
+
+	if !s.checkRate(s.clientLimiter, resp, req) {
+		return
+	}
+
 	if urlPath == "/" {
 		s.serve(resp, req, urlPath, -1, rootRoute, false)
 		return
 	}
 
+	if s.dev != nil && urlPath == _devReloadPath {
+		s.dev.serveEvents(resp, req)
+		return
+	}
+
 	// TODO: I think I should have a separate server for resources
 
 	// Check for static resource request
@@ -369,32 +477,86 @@ func (s *server) serve(
 	tailWasCached bool,
 ) {
 
+	if fh.security != nil {
+		fh.security.replace(s.security, s, resp, req)
+	}
+
+	if !s.checkRate(fh.limiter, resp, req) {
+		return
+	}
+
 	var respHdrs = resp.Header()
 	respHdrs[_contentType] = htmlContentHeader
 
 	var doGzip = s.compressionLevel != 0 &&
 		strings.Contains(req.Header.Get(_acceptEncoding), _gzip)
 
+	if fh.cache.serves(req) {
+		s.serveCached(resp, req, fh, doGzip)
+		return
+	}
+
 	if doGzip {
 		respHdrs[_contentEncoding] = gzipHeader
 	}
 
+	if fh.route.ETag != NoETag && s.compressionLevel != 0 {
+		// The tag differs between the plain and gzipped body
+		respHdrs[_vary] = acceptEncodingHeader
+	}
+
 	var r = getResponse(s, resp, req, fh.siteMapNode, doGzip)
 	defer putResponse(s, r)
 
-	fh.route.Handler(&RouteResponse{r: r.response}, &RouteData{})
+	s.runHandler(r, fh)
 
 	if r.responseState.has(sent) {
 		// TODO: Need to actually be handling HTTP error types
 		return
 	}
 
+	r.send(fh.route.ETag)
+
 	var hasURLTail = tailIdx != -1
 	if hasURLTail && !tailWasCached && r.responseState.has(cacheTail) {
 		s.addTailRoute(fh, fullPth)
 	}
 }
 
+// runHandler calls the route's handler, limited by its RenderTimeout and by
+// the cap on concurrent renders. If the response failed, the error page is
+// sent.
+func (s *server) runHandler(r *responseBase[*RouteData], fh *freakHandler) {
+	if !s.acquireRender() {
+		r.resp.Header()[_retryAfter] = []string{_concurrencyRetryAfterSecs}
+		r.fail(http.StatusServiceUnavailable, ErrTooBusy)
+		s.sendError(r)
+		return
+	}
+	defer s.releaseRender()
+
+	defer unlockRender(lockRender())
+
+	if fh.route.RenderTimeout > 0 {
+		var ctx, cancel = context.WithTimeout(r.Context(), fh.route.RenderTimeout)
+		defer cancel()
+
+		r.setContext(ctx)
+	}
+
+	s.setNonce(&r.response)
+
+	if s.checkCSRF(&r.response, fh) {
+		fh.route.Handler(&RouteResponse{r: &r.response}, &RouteData{})
+	} else {
+		r.fail(http.StatusForbidden, ErrCSRF)
+	}
+
+	if r.responseState.has(failed) {
+		s.sendError(r)
+	}
+}
+
 var errNilComponent = fmt.Errorf("handler returned a nil component")
 
 func cleanPath(urlPath string) string {
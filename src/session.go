@@ -0,0 +1,273 @@
+package freak
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	_defaultSessionCookie     = "freak-session"
+	_defaultSessionMaxAge     = 24 * time.Hour
+	_defaultSessionGCInterval = 10 * time.Minute
+	_sessionIDLen             = 32
+)
+
+// SessionData is what a SessionStore keeps for each session.
+type SessionData struct {
+	Values  map[string]string
+	Expires time.Time
+}
+
+// SessionStore keeps the data of sessions by their ID. It must be safe for
+// concurrent use.
+type SessionStore interface {
+	// Load returns the data of the session, or `nil` if there is none.
+	Load(id string) (*SessionData, error)
+
+	// Save stores the data of the session, replacing any previous data.
+	Save(id string, data *SessionData) error
+
+	// Delete removes the session. Deleting a missing session is not an error.
+	Delete(id string) error
+
+	// GC removes all the sessions that expired before `now`.
+	GC(now time.Time) error
+}
+
+// SessionConfig configures the sessions of a Server. Only the Store is
+// required.
+type SessionConfig struct {
+	Store SessionStore
+
+	// CookieName defaults to "freak-session".
+	CookieName string
+
+	// MaxAge is how long an idle session lives. It defaults to 24 hours, and
+	// is renewed as the session is used.
+	MaxAge time.Duration
+
+	// Encrypt hides the session ID in the cookie, instead of only signing it.
+	Encrypt bool
+
+	// Secure and SameSite are set on the session cookie. SameSite defaults to
+	// http.SameSiteLaxMode.
+	Secure   bool
+	SameSite http.SameSite
+
+	// GCInterval is how often expired sessions are swept from the Store. It
+	// defaults to 10 minutes.
+	GCInterval time.Duration
+}
+
+// SetSessions enables sessions for all routes. SetSecretKey must be called
+// first, since the session ID in the cookie is signed or encrypted with it.
+func (s *Server) SetSessions(config SessionConfig) error {
+	return (*server)(s).setSessions(config)
+}
+
+func (s *server) setSessions(config SessionConfig) error {
+	if s.isStarted {
+		return fmt.Errorf("Server is already running")
+	}
+	if config.Store == nil {
+		return fmt.Errorf("SessionConfig needs a Store")
+	}
+	if s.secret == nil {
+		return fmt.Errorf("SetSecretKey must be called before SetSessions")
+	}
+
+	if len(config.CookieName) == 0 {
+		config.CookieName = _defaultSessionCookie
+	}
+	if config.MaxAge <= 0 {
+		config.MaxAge = _defaultSessionMaxAge
+	}
+	if config.SameSite == 0 {
+		config.SameSite = http.SameSiteLaxMode
+	}
+	if config.GCInterval <= 0 {
+		config.GCInterval = _defaultSessionGCInterval
+	}
+
+	s.sessions = &sessions{SessionConfig: config, secret: s.secret}
+	return nil
+}
+
+type sessions struct {
+	SessionConfig
+	secret *secret
+}
+
+func (m *sessions) encode(id string) string {
+	if m.Encrypt {
+		return m.secret.encrypt(m.CookieName, id)
+	}
+	return m.secret.sign(m.CookieName, id)
+}
+
+func (m *sessions) decode(value string) (string, bool) {
+	if m.Encrypt {
+		return m.secret.decrypt(m.CookieName, value)
+	}
+	return m.secret.verify(m.CookieName, value)
+}
+
+// load returns the session of the request's cookie. If there is none, or it
+// is invalid or expired, a new session is returned that is only stored once
+// something is set in it.
+func (m *sessions) load(req *http.Request) *Session {
+	if c, err := req.Cookie(m.CookieName); err == nil {
+		if id, ok := m.decode(c.Value); ok {
+			data, err := m.Store.Load(id)
+
+			if err != nil {
+				fmt.Printf("Session load error: %q\n", err)
+
+			} else if data != nil && time.Now().Before(data.Expires) {
+				if data.Values == nil {
+					data.Values = map[string]string{}
+				}
+				return &Session{manager: m, id: id, data: *data, hadCookie: true}
+			}
+		}
+
+		return &Session{manager: m, data: SessionData{Values: map[string]string{}}, hadCookie: true}
+	}
+
+	return &Session{manager: m, data: SessionData{Values: map[string]string{}}}
+}
+
+// sweep runs the Store's GC on the configured interval.
+func (m *sessions) sweep() {
+	for now := range time.Tick(m.GCInterval) {
+		if err := m.Store.GC(now); err != nil {
+			fmt.Printf("Session GC error: %q\n", err)
+		}
+	}
+}
+
+// Session holds the values of a user's session. It is only valid during the
+// request it was received in.
+type Session struct {
+	manager *sessions
+
+	id, oldID string
+	data      SessionData
+
+	hadCookie, modified, rotated, destroyed bool
+}
+
+// Get returns the value of `key`, or an empty string.
+func (s *Session) Get(key string) string {
+	return s.data.Values[key]
+}
+
+// Set sets the value of `key`.
+func (s *Session) Set(key, val string) {
+	s.data.Values[key] = val
+	s.modified = true
+}
+
+// Delete removes `key` from the session.
+func (s *Session) Delete(key string) {
+	if _, ok := s.data.Values[key]; ok {
+		delete(s.data.Values, key)
+		s.modified = true
+	}
+}
+
+// IsNew reports if the session was started by this request.
+func (s *Session) IsNew() bool {
+	return len(s.id) == 0 && !s.rotated
+}
+
+// RotateID gives the session a new ID, and removes the old one from the
+// store. It should be called whenever the user's privileges change, like when
+// logging in or out, so that a previously known ID becomes useless.
+func (s *Session) RotateID() {
+	if len(s.id) != 0 {
+		s.oldID = s.id
+	}
+	s.id = ""
+	s.rotated = true
+}
+
+// Destroy removes the session from the store, and expires its cookie.
+func (s *Session) Destroy() {
+	s.destroyed = true
+}
+
+// commit saves the session if it changed, or if it's due to be renewed, and
+// returns the cookie to send, if any.
+func (s *Session) commit(now time.Time) *http.Cookie {
+	var m = s.manager
+
+	if s.destroyed {
+		for _, id := range [...]string{s.id, s.oldID} {
+			if len(id) != 0 {
+				if err := m.Store.Delete(id); err != nil {
+					fmt.Printf("Session delete error: %q\n", err)
+				}
+			}
+		}
+
+		if !s.hadCookie {
+			return nil
+		}
+		return m.cookie("", -1, time.Time{})
+	}
+
+	var renew = len(s.id) != 0 && s.data.Expires.Sub(now) < m.MaxAge/2
+
+	if !s.modified && !s.rotated && !renew {
+		return nil
+	}
+
+	if len(s.id) == 0 {
+		s.id = randomString(_sessionIDLen)
+	}
+
+	if len(s.oldID) != 0 {
+		if err := m.Store.Delete(s.oldID); err != nil {
+			fmt.Printf("Session delete error: %q\n", err)
+		}
+	}
+
+	s.data.Expires = now.Add(m.MaxAge)
+
+	if err := m.Store.Save(s.id, &s.data); err != nil {
+		fmt.Printf("Session save error: %q\n", err)
+		return nil
+	}
+
+	return m.cookie(m.encode(s.id), int(m.MaxAge/time.Second), s.data.Expires)
+}
+
+func (m *sessions) cookie(value string, maxAge int, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.CookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+	}
+}
+
+// Session returns the user's session, or `nil` if sessions are not enabled.
+// A new session is only stored once a value is set in it. A page that uses
+// the session is not cached, since it may show one user's data.
+func (r *RouteResponse) Session() *Session {
+	return r.r.getSession()
+}
+
+func (r *response[T]) getSession() *Session {
+	if r.session == nil && r.server != nil && r.server.sessions != nil {
+		r.session = r.server.sessions.load(r.req)
+		r.responseState.set(skipCache)
+	}
+	return r.session
+}
@@ -0,0 +1,187 @@
+package freak
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemorySessionStore keeps sessions in memory. They are lost when the server
+// stops, and are not shared between servers.
+type MemorySessionStore struct {
+	mux      sync.RWMutex
+	sessions map[string]SessionData
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: map[string]SessionData{}}
+}
+
+func (m *MemorySessionStore) Load(id string) (*SessionData, error) {
+	m.mux.RLock()
+	data, ok := m.sessions[id]
+	m.mux.RUnlock()
+
+	if !ok {
+		return nil, nil
+	}
+
+	data.Values = copyValues(data.Values)
+	return &data, nil
+}
+
+func (m *MemorySessionStore) Save(id string, data *SessionData) error {
+	var cp = SessionData{Values: copyValues(data.Values), Expires: data.Expires}
+
+	m.mux.Lock()
+	m.sessions[id] = cp
+	m.mux.Unlock()
+
+	return nil
+}
+
+func (m *MemorySessionStore) Delete(id string) error {
+	m.mux.Lock()
+	delete(m.sessions, id)
+	m.mux.Unlock()
+
+	return nil
+}
+
+func (m *MemorySessionStore) GC(now time.Time) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	for id, data := range m.sessions {
+		if !now.Before(data.Expires) {
+			delete(m.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+func copyValues(vals map[string]string) map[string]string {
+	var cp = make(map[string]string, len(vals))
+	for k, v := range vals {
+		cp[k] = v
+	}
+	return cp
+}
+
+// FileSessionStore keeps each session as a JSON file in a directory, so they
+// survive a restart.
+type FileSessionStore struct {
+	dir string
+	mux sync.RWMutex
+}
+
+const _sessionFileExt = ".session"
+
+// NewFileSessionStore creates the store, and the directory if needed.
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+// path returns the session's file path. IDs are URL-safe base64, so anything
+// else is rejected to keep the path inside the directory.
+func (f *FileSessionStore) path(id string) (string, error) {
+	if len(id) == 0 || strings.Trim(id, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+		return "", fmt.Errorf("invalid session id %q", id)
+	}
+	return filepath.Join(f.dir, id+_sessionFileExt), nil
+}
+
+func (f *FileSessionStore) Load(id string) (*SessionData, error) {
+	pth, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mux.RLock()
+	b, err := os.ReadFile(pth)
+	f.mux.RUnlock()
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data SessionData
+	if err = json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (f *FileSessionStore) Save(id string, data *SessionData) error {
+	pth, err := f.path(id)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	// Written to a temporary file first, so a reader never sees half of it
+	var tmp = pth + ".tmp"
+
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, pth)
+}
+
+func (f *FileSessionStore) Delete(id string) error {
+	pth, err := f.path(id)
+	if err != nil {
+		return err
+	}
+
+	f.mux.Lock()
+	err = os.Remove(pth)
+	f.mux.Unlock()
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *FileSessionStore) GC(now time.Time) error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		var name = entry.Name()
+		if !strings.HasSuffix(name, _sessionFileExt) {
+			continue
+		}
+
+		var id = strings.TrimSuffix(name, _sessionFileExt)
+
+		data, err := f.Load(id)
+		if err != nil || (data != nil && !now.Before(data.Expires)) {
+			// Unreadable files are removed too, since they can never be loaded
+			f.Delete(id)
+		}
+	}
+
+	return nil
+}
@@ -145,3 +145,13 @@ func (smn *SiteMapNode) GetAncestor(idx int) *SiteMapNode {
 func (smn *SiteMapNode) LenAncestors() int {
 	return len(smn.ancestors)
 }
+
+// SiteMapNode returns the node of the requested page, or nil if there is none.
+func (r *response[T]) SiteMapNode() *SiteMapNode {
+	return r.siteMapNode
+}
+
+// SiteMapNode returns the node of the requested page, or nil if there is none.
+func (r *RouteResponse) SiteMapNode() *SiteMapNode {
+	return r.r.siteMapNode
+}
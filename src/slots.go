@@ -0,0 +1,88 @@
+package freak
+
+import "strings"
+
+const _slotPrefix = "freak-slot:"
+
+// Slots holds the callbacks that fill the named slots of a layout, which are
+// marked by comments like `<!-- freak-slot: sidebar -->`. Each callback writes
+// its content, usually by inserting components with the parent's response.
+type Slots map[string]func()
+
+// slotSet is the Slots of one InsertSlots, and those of the layout around it.
+type slotSet struct {
+	fill  Slots
+	outer *slotSet
+}
+
+// InsertSlots renders the layout with its slots filled by `slots`. Slots that
+// have no callback are left empty. A slot's content may insert wrappers, and
+// other layouts with their own slots.
+func InsertSlots[P, C any](r *response[P], layout Component[C], data C, slots Slots) {
+	var outer = r.slots
+	var set = &slotSet{fill: slots, outer: outer}
+
+	r.slots = set
+	InsertChild(r, layout, data)
+	r.slots = outer
+
+	// The second half of a layout that is a wrapper is rendered later, so its
+	// slots are set again for it.
+	if ending := r.wrapperEndings; ending != nil && layout.wrapperContentMarkerIndex != -1 {
+		r.wrapperEndings = func() {
+			var current = r.slots
+
+			r.slots = set
+			ending()
+			r.slots = current
+		}
+	}
+}
+
+// slotName returns the name of the slot that a comment marks.
+func slotName(comment string) (string, bool) {
+	comment = strings.TrimSpace(comment)
+
+	if !strings.HasPrefix(comment, _slotPrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(comment[len(_slotPrefix):]), true
+}
+
+// newSlotMarker creates the marker that fills the slot at `pos`. It has no
+// width, since the comment isn't rendered.
+func newSlotMarker[T any](name string, pos uint32) *marker[T] {
+	var m = marker[T]{isSlot: true}
+
+	m.callbacks[preCallbackIndex] = callbackPos[T]{
+		callback: func(r *response[T], _ T) {
+			r.fillSlot(name)
+		},
+		pos:    pos,
+		endPos: pos,
+	}
+
+	return &m
+}
+
+// fillSlot calls the slot's callback. The layout's own slots are set aside
+// meanwhile, since the content belongs to the layout's parent. Wrappers that
+// the content inserted are completed at once, since a slot has no element for
+// them to wrap.
+func (r *response[T]) fillSlot(name string) {
+	var set = r.slots
+	if set == nil || set.fill[name] == nil {
+		return
+	}
+
+	r.slots = set.outer
+	set.fill[name]()
+
+	if ending := r.wrapperEndings; ending != nil {
+		r.wrapperEndings = nil
+		ending()
+		r.wrapperEndings = nil
+	}
+
+	r.slots = set
+}